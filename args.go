@@ -1,44 +1,113 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
 )
 
 type config struct {
+	command    string // "" = open the path, "pr" = the branch's pull request, "compare" = its compare view, "resolve" = a URL back to a path
+	base       string // compare: branch to compare against; "" = the remote's default branch
+	resolveURL string // resolve: the page URL to read back
+	edit       bool   // resolve --edit: open the file in $VISUAL/$EDITOR instead of printing it
 	version    bool
 	remoteName string
+	remoteSet  bool // -r given: the branch's upstream does not pick the remote
+	noUpstream bool // --no-upstream: use the local branch name, not the upstream's
+	strict     bool // --strict: fail, rather than warn or fall back, when HEAD is not pushed
 	copy       bool
 	print      bool
 	line       string
 	commit     string
+	ref        string // --ref: branch, tag or remote-tracking branch to open instead of HEAD's branch
+	permalink  bool
+	blame      bool
+	history    bool
 	completion string // "auto" = detect from $SHELL, "bash"/"zsh"/"fish" = explicit
+	stdin      bool   // --stdin: read path[:line] entries from standard input, one URL each
+	format     string // --format: one of formatNames or template=<template>; "" = the bare URL
 	paths      []string
+	lines      []string // lines[i] is the line paths[i] carried (main.go:42), "" for none; nil when none did
+}
+
+// lineAt returns the line to open paths[i] at: the one it carried, else
+// --line's.
+func (c config) lineAt(i int) string {
+	if i < len(c.lines) && c.lines[i] != "" {
+		return c.lines[i]
+	}
+	return c.line
 }
 
 func usage() {
-	fmt.Fprintf(os.Stderr, `Usage: gopen [flags] [path]
+	fmt.Fprintf(os.Stderr, `Usage: gopen [flags] [path[:line[:col]]...]
+       gopen pr [flags] [path]
+       gopen compare [flags] [base]
+       gopen resolve [flags] <url>
+       gopen --stdin [flags] < paths
 
 Open a Git repository path in the browser at the current branch.
 
+Commands:
+  pr                   Open the pull/merge request for the current branch
+  compare [base]       Compare the current branch against base
+                       (default: the remote's default branch)
+  resolve <url>        Print the local path:line a forge URL points at
+                       (matched against every remote, or just -r's)
+
 Flags:
   -v, --version        Print version information
   -c, --copy           Copy URL to clipboard instead of opening browser
+                       (several are copied one per line)
   -p, --print          Print the URL to stdout and exit (no browser, no clipboard)
                        Takes precedence over -c/--copy when both are given
-  -r, --remote <name>  Git remote to use (default: origin)
+  -r, --remote <name>  Git remote to use (default: the one the branch tracks,
+                       else origin)
+      --no-upstream    Use the local branch name even when the branch tracks
+                       one of another name
+      --strict         Fail when HEAD is not pushed to the remote, instead of
+                       warning or linking the last pushed commit
   -l, --line <n[-m]>   Highlight line or range (e.g. 42 or 42-50)
       --commit <hash>  Open a specific commit or file at that commit
+      --ref <name>     Open at a branch, tag or remote-tracking branch instead
+                       of the current branch
+      --permalink      Pin the URL to the commit HEAD resolves to, not the branch
+      --blame          Open the blame view of the file instead of its contents
+      --history        Open the commits that touched the path (file or directory)
+      --edit           resolve: open the file in $VISUAL or $EDITOR instead
+      --stdin          Read path[:line] entries, one per line, from stdin and
+                       print a URL for each, in order (-c copies them instead)
+      --format <fmt>   Print each URL as a link instead: markdown, html, org,
+                       rst or slack; json for an object with the remote, ref,
+                       path and lines it was built from; or template=<text>
+                       for a Go text/template of your own (-c copies it)
       --completion [shell]  Output shell completion script (bash, zsh, fish)
 
 Examples:
   gopen                        # current directory
   gopen main.go                # file on current branch
   gopen main.go -l 42          # file at line 42
+  gopen main.go:42:7           # same, as compilers and grep -n print it
   gopen -p main.go             # print URL, useful in scripts
+  gopen -c main.go url.go:12   # copy both URLs, one per line
   gopen --commit abc1234       # commit page
   gopen --commit abc1234 -c    # copy commit URL
+  gopen --permalink main.go    # file pinned to HEAD's commit
+  gopen --ref v1.2.0 main.go   # file as of tag v1.2.0
+  gopen --blame main.go -l 42  # who last touched line 42
+  gopen --history docs/        # commits that touched docs/
+  gopen pr                     # pull request for the current branch
+  gopen compare -r upstream    # compare a fork's branch against upstream
+  gopen resolve --edit <url>   # open a linked file and line in the editor
+  git diff --name-only --relative main | gopen --stdin
+                               # a URL for every file the branch changes
+  gopen --format json main.go  # URL and its details, for editors and scripts
+  gopen --format markdown -c main.go:42
+                               # copy a Markdown link to line 42, for the docs
   gopen --completion           # shell completion script (auto-detected)
   gopen --completion=zsh       # zsh completion script
 `)
@@ -46,9 +115,20 @@ Examples:
 
 // parseArgs parses flags and positional arguments in any order.
 // Supports: --flag value, --flag=value, -f value, -fvalue (for -l/-r).
+// A command is only recognised as the first argument, so `gopen -- pr` and
+// `gopen main.go pr` still open a path named pr.
+//
+// A path may carry its line the way compilers, linters and grep -n print it,
+// main.go:42, main.go:42:7 or main.go:42-50, or the way forges anchor it,
+// main.go#L42; see splitLocation. Arguments after -- are taken literally.
 func parseArgs(args []string) (config, error) {
 	cfg := config{remoteName: "origin"}
 
+	if len(args) > 0 && isCommand(args[0]) {
+		cfg.command = args[0]
+		args = args[1:]
+	}
+
 	for i := 0; i < len(args); i++ {
 		arg := args[i]
 
@@ -73,7 +153,7 @@ func parseArgs(args []string) (config, error) {
 			if err != nil {
 				return cfg, err
 			}
-			cfg.remoteName = v
+			cfg.remoteName, cfg.remoteSet = v, true
 		case "-l", "--line":
 			v, err := nextVal()
 			if err != nil {
@@ -86,6 +166,32 @@ func parseArgs(args []string) (config, error) {
 				return cfg, err
 			}
 			cfg.commit = v
+		case "--ref":
+			v, err := nextVal()
+			if err != nil {
+				return cfg, err
+			}
+			cfg.ref = v
+		case "--format":
+			v, err := nextVal()
+			if err != nil {
+				return cfg, err
+			}
+			cfg.format = v
+		case "--no-upstream":
+			cfg.noUpstream = true
+		case "--strict":
+			cfg.strict = true
+		case "--permalink":
+			cfg.permalink = true
+		case "--blame":
+			cfg.blame = true
+		case "--history":
+			cfg.history = true
+		case "--edit":
+			cfg.edit = true
+		case "--stdin":
+			cfg.stdin = true
 		case "--completion":
 			// Optional shell arg: --completion [bash|zsh|fish]
 			if i+1 < len(args) && isKnownShell(args[i+1]) {
@@ -96,31 +202,162 @@ func parseArgs(args []string) (config, error) {
 			}
 		case "--":
 			cfg.paths = append(cfg.paths, args[i+1:]...)
-			return cfg, nil
+			cfg.lines = append(cfg.lines, make([]string, len(args)-i-1)...)
+			i = len(args)
 		default:
 			switch {
 			case strings.HasPrefix(arg, "--remote="):
-				cfg.remoteName = arg[len("--remote="):]
+				cfg.remoteName, cfg.remoteSet = arg[len("--remote="):], true
 			case strings.HasPrefix(arg, "--line="):
 				cfg.line = arg[len("--line="):]
 			case strings.HasPrefix(arg, "--commit="):
 				cfg.commit = arg[len("--commit="):]
+			case strings.HasPrefix(arg, "--ref="):
+				cfg.ref = arg[len("--ref="):]
+			case strings.HasPrefix(arg, "--format="):
+				cfg.format = arg[len("--format="):]
 			case strings.HasPrefix(arg, "--completion="):
 				cfg.completion = arg[len("--completion="):]
 			case len(arg) > 2 && arg[0] == '-' && arg[1] == 'r':
-				cfg.remoteName = arg[2:] // -rorigin
+				cfg.remoteName, cfg.remoteSet = arg[2:], true // -rorigin
 			case len(arg) > 2 && arg[0] == '-' && arg[1] == 'l':
 				cfg.line = arg[2:] // -l42
 			case strings.HasPrefix(arg, "-"):
 				return cfg, fmt.Errorf("unknown flag: %s", arg)
 			default:
-				cfg.paths = append(cfg.paths, arg)
+				path, line := arg, ""
+				if cfg.command == "" || cfg.command == "pr" {
+					path, line = splitLocation(arg, pathExists)
+				}
+				cfg.paths = append(cfg.paths, path)
+				cfg.lines = append(cfg.lines, line)
 			}
 		}
 	}
+	carried := false
+	for i, line := range cfg.lines {
+		if line == "" {
+			continue
+		}
+		if cfg.line != "" && cfg.line != line {
+			return cfg, fmt.Errorf("--line %s conflicts with line %s of %s", cfg.line, line, cfg.paths[i])
+		}
+		carried = true
+	}
+	if !carried {
+		cfg.lines = nil
+	}
+
+	// compare's positional is the base branch; the repository is always the
+	// one around the working directory.
+	if cfg.command == "compare" && len(cfg.paths) > 0 {
+		if len(cfg.paths) > 1 {
+			return cfg, fmt.Errorf("compare takes at most one base branch, got %q", cfg.paths)
+		}
+		cfg.base, cfg.paths = cfg.paths[0], nil
+	}
+	if cfg.command == "pr" && len(cfg.paths) > 1 {
+		return cfg, fmt.Errorf("pr takes at most one path, got %q", cfg.paths)
+	}
+	if cfg.command == "resolve" {
+		if len(cfg.paths) != 1 {
+			return cfg, fmt.Errorf("resolve takes one URL, got %d arguments", len(cfg.paths))
+		}
+		cfg.resolveURL, cfg.paths = cfg.paths[0], nil
+	}
+	if cfg.edit && cfg.command != "resolve" {
+		return cfg, errors.New("--edit only applies to resolve")
+	}
+	if cfg.format != "" {
+		if err := checkFormat(cfg.format); err != nil {
+			return cfg, err
+		}
+		if cfg.command == "resolve" {
+			return cfg, errors.New("--format does not apply to resolve")
+		}
+	}
+	if cfg.stdin {
+		switch {
+		case cfg.command != "":
+			return cfg, fmt.Errorf("--stdin does not apply to %s", cfg.command)
+		case len(cfg.paths) > 0:
+			return cfg, errors.New("--stdin reads the paths from standard input, not from arguments")
+		case cfg.line != "":
+			return cfg, errors.New("--stdin takes each line from its entry (path:line), not from --line")
+		}
+	}
 	return cfg, nil
 }
 
+func isCommand(s string) bool {
+	return s == "pr" || s == "compare" || s == "resolve"
+}
+
 func isKnownShell(s string) bool {
 	return s == "bash" || s == "zsh" || s == "fish"
 }
+
+// locationSuffix matches what follows a path's colon in a compiler or grep
+// location: the line or range, then an optional column, then anything a
+// diagnostic or matched line goes on with ("42:7: undefined: x").
+var locationSuffix = regexp.MustCompile(`^([0-9]+)(?:-([0-9]+))?(?::[0-9]+)?(?::.*)?$`)
+
+// anchorSuffix matches a forge line anchor after a path's #: L42, L42-L50.
+var anchorSuffix = regexp.MustCompile(`^L([0-9]+)(?:-L?([0-9]+))?$`)
+
+// splitLocation splits a path argument carrying a line, path:line[:col],
+// path:start-end or path#L42, into the path and the line in --line's form. The
+// column is dropped, since no forge links to one.
+//
+// File names may contain colons and hashes themselves, so an argument that
+// names an existing path as a whole is never split, and among the ways to
+// split it the first that leaves an existing path wins. When none does, the
+// first split is kept, so the error names the file that is missing rather
+// than the whole location.
+func splitLocation(arg string, exists func(string) bool) (path, line string) {
+	if exists(arg) {
+		return arg, ""
+	}
+	type candidate struct{ path, line string }
+	var candidates []candidate
+	for i := 1; i < len(arg); i++ {
+		var m []string
+		switch arg[i] {
+		case ':':
+			m = locationSuffix.FindStringSubmatch(arg[i+1:])
+		case '#':
+			m = anchorSuffix.FindStringSubmatch(arg[i+1:])
+		}
+		if m == nil {
+			continue
+		}
+		line := m[1]
+		if m[2] != "" && m[2] != m[1] {
+			line += "-" + m[2]
+		}
+		candidates = append(candidates, candidate{arg[:i], line})
+	}
+	if len(candidates) == 0 {
+		return arg, ""
+	}
+	for _, c := range candidates {
+		if exists(c.path) {
+			return c.path, c.line
+		}
+	}
+	return candidates[0].path, candidates[0].line
+}
+
+// pathExists reports whether p, relative to the directory gopen was run from,
+// exists.
+func pathExists(p string) bool {
+	if !filepath.IsAbs(p) {
+		cwd, err := effectiveCwd()
+		if err != nil {
+			return false
+		}
+		p = filepath.Join(cwd, p)
+	}
+	_, err := os.Stat(p)
+	return err == nil
+}
@@ -45,22 +45,22 @@ func TestParseArgs(t *testing.T) {
 		{
 			name: "remote short",
 			args: []string{"-r", "upstream"},
-			want: config{remoteName: "upstream"},
+			want: config{remoteName: "upstream", remoteSet: true},
 		},
 		{
 			name: "remote long",
 			args: []string{"--remote", "upstream"},
-			want: config{remoteName: "upstream"},
+			want: config{remoteName: "upstream", remoteSet: true},
 		},
 		{
 			name: "remote attached short",
 			args: []string{"-rupstream"},
-			want: config{remoteName: "upstream"},
+			want: config{remoteName: "upstream", remoteSet: true},
 		},
 		{
 			name: "remote equals long",
 			args: []string{"--remote=upstream"},
-			want: config{remoteName: "upstream"},
+			want: config{remoteName: "upstream", remoteSet: true},
 		},
 
 		// --line / -l
@@ -102,6 +102,174 @@ func TestParseArgs(t *testing.T) {
 			want: config{remoteName: "origin", commit: "abc1234"},
 		},
 
+		// --no-upstream
+		{
+			name: "no upstream",
+			args: []string{"--no-upstream", "main.go"},
+			want: config{remoteName: "origin", noUpstream: true, paths: []string{"main.go"}},
+		},
+
+		// --strict
+		{
+			name: "strict",
+			args: []string{"--strict", "--permalink"},
+			want: config{remoteName: "origin", strict: true, permalink: true},
+		},
+
+		// --ref
+		{
+			name: "ref long",
+			args: []string{"--ref", "v1.2.0", "main.go"},
+			want: config{remoteName: "origin", ref: "v1.2.0", paths: []string{"main.go"}},
+		},
+		{
+			name: "ref equals",
+			args: []string{"--ref=origin/release/2.x"},
+			want: config{remoteName: "origin", ref: "origin/release/2.x"},
+		},
+
+		// --permalink
+		{
+			name: "permalink",
+			args: []string{"--permalink", "main.go", "-l", "42"},
+			want: config{remoteName: "origin", permalink: true, paths: []string{"main.go"}, line: "42"},
+		},
+
+		// --blame
+		{
+			name: "blame",
+			args: []string{"--blame", "main.go", "-l", "42-50"},
+			want: config{remoteName: "origin", blame: true, paths: []string{"main.go"}, line: "42-50"},
+		},
+
+		// --history
+		{
+			name: "history",
+			args: []string{"--history", "docs/"},
+			want: config{remoteName: "origin", history: true, paths: []string{"docs/"}},
+		},
+
+		// Several paths
+		{
+			name: "several paths",
+			args: []string{"main.go", "args.go", "-c"},
+			want: config{remoteName: "origin", paths: []string{"main.go", "args.go"}, copy: true},
+		},
+
+		// Commands
+		{
+			name: "pr command",
+			args: []string{"pr"},
+			want: config{remoteName: "origin", command: "pr"},
+		},
+		{
+			name: "pr command with flags and a path",
+			args: []string{"pr", "-r", "upstream", "-p", "sub/"},
+			want: config{remoteName: "upstream", remoteSet: true, command: "pr", print: true, paths: []string{"sub/"}},
+		},
+		{
+			name:    "pr with two paths",
+			args:    []string{"pr", "sub/", "other/"},
+			wantErr: true,
+		},
+		{
+			name: "pr after another argument is a path",
+			args: []string{"main.go", "pr"},
+			want: config{remoteName: "origin", paths: []string{"main.go", "pr"}},
+		},
+		{
+			name: "pr after double dash is a path",
+			args: []string{"--", "pr"},
+			want: config{remoteName: "origin", paths: []string{"pr"}},
+		},
+
+		{
+			name: "compare without a base",
+			args: []string{"compare"},
+			want: config{remoteName: "origin", command: "compare"},
+		},
+		{
+			name: "compare with a base",
+			args: []string{"compare", "-r", "upstream", "develop"},
+			want: config{remoteName: "upstream", remoteSet: true, command: "compare", base: "develop"},
+		},
+		{
+			name: "compare base after double dash",
+			args: []string{"compare", "--", "-odd"},
+			want: config{remoteName: "origin", command: "compare", base: "-odd"},
+		},
+		{
+			name:    "compare with two bases",
+			args:    []string{"compare", "main", "develop"},
+			wantErr: true,
+		},
+
+		// resolve
+		{
+			name: "resolve",
+			args: []string{"resolve", "https://github.com/user/repo/blob/main/main.go#L42"},
+			want: config{remoteName: "origin", command: "resolve", resolveURL: "https://github.com/user/repo/blob/main/main.go#L42"},
+		},
+		{
+			name: "resolve in the editor, against one remote",
+			args: []string{"resolve", "--edit", "-r", "upstream", "https://gitlab.com/org/repo/-/blob/main/main.go"},
+			want: config{remoteName: "upstream", remoteSet: true, command: "resolve", edit: true, resolveURL: "https://gitlab.com/org/repo/-/blob/main/main.go"},
+		},
+		{
+			name:    "resolve without a URL",
+			args:    []string{"resolve"},
+			wantErr: true,
+		},
+		{
+			name:    "edit without resolve",
+			args:    []string{"--edit", "main.go"},
+			wantErr: true,
+		},
+
+		// --stdin
+		{
+			name: "stdin",
+			args: []string{"--stdin", "-p", "--blame"},
+			want: config{remoteName: "origin", stdin: true, print: true, blame: true},
+		},
+		{
+			name:    "stdin with a path",
+			args:    []string{"--stdin", "main.go"},
+			wantErr: true,
+		},
+		{
+			name:    "stdin with --line",
+			args:    []string{"--stdin", "-l", "42"},
+			wantErr: true,
+		},
+		{
+			name:    "stdin with a command",
+			args:    []string{"pr", "--stdin"},
+			wantErr: true,
+		},
+
+		// --format
+		{
+			name: "format json",
+			args: []string{"--format", "json", "main.go"},
+			want: config{remoteName: "origin", format: "json", paths: []string{"main.go"}},
+		},
+		{
+			name: "format equals, with stdin",
+			args: []string{"--format=json", "--stdin"},
+			want: config{remoteName: "origin", format: "json", stdin: true},
+		},
+		{
+			name:    "format unknown",
+			args:    []string{"--format", "xml"},
+			wantErr: true,
+		},
+		{
+			name:    "format with resolve",
+			args:    []string{"resolve", "--format", "json", "https://github.com/user/repo"},
+			wantErr: true,
+		},
+
 		// --completion
 		{
 			name: "completion auto (no shell arg)",
@@ -156,6 +324,53 @@ func TestParseArgs(t *testing.T) {
 			want: config{remoteName: "origin", paths: []string{"main.go"}, copy: true, commit: "abc"},
 		},
 
+		// Locations
+		{
+			name: "path:line",
+			args: []string{"main.go:42"},
+			want: config{remoteName: "origin", paths: []string{"main.go"}, lines: []string{"42"}},
+		},
+		{
+			name: "compiler diagnostic with a column",
+			args: []string{"-p", "main.go:42:7:"},
+			want: config{remoteName: "origin", print: true, paths: []string{"main.go"}, lines: []string{"42"}},
+		},
+		{
+			name: "path:start-end",
+			args: []string{"main.go:42-50"},
+			want: config{remoteName: "origin", paths: []string{"main.go"}, lines: []string{"42-50"}},
+		},
+		{
+			name: "forge anchor",
+			args: []string{"main.go#L42-L50"},
+			want: config{remoteName: "origin", paths: []string{"main.go"}, lines: []string{"42-50"}},
+		},
+		{
+			name: "location agreeing with -l",
+			args: []string{"main.go:42", "-l", "42"},
+			want: config{remoteName: "origin", paths: []string{"main.go"}, line: "42", lines: []string{"42"}},
+		},
+		{
+			name:    "location conflicting with -l",
+			args:    []string{"-l", "50", "main.go:42"},
+			wantErr: true,
+		},
+		{
+			name: "each path carries its own line",
+			args: []string{"main.go:42", "args.go:7-9", "--", "url.go"},
+			want: config{remoteName: "origin", paths: []string{"main.go", "args.go", "url.go"}, lines: []string{"42", "7-9", ""}},
+		},
+		{
+			name: "location after double dash is a literal path",
+			args: []string{"--", "main.go:42"},
+			want: config{remoteName: "origin", paths: []string{"main.go:42"}},
+		},
+		{
+			name: "compare base is not a location",
+			args: []string{"compare", "v1:2"},
+			want: config{remoteName: "origin", command: "compare", base: "v1:2"},
+		},
+
 		// Double dash separator
 		{
 			name: "double dash passes remaining as paths",
@@ -204,6 +419,58 @@ func TestParseArgs(t *testing.T) {
 	}
 }
 
+func TestSplitLocation(t *testing.T) {
+	existing := map[string]bool{
+		"main.go":     true,
+		"notes:2.txt": true,
+		"a:1":         true,
+		"dir/x.go":    true,
+	}
+	exists := func(p string) bool { return existing[p] }
+
+	tests := []struct {
+		arg      string
+		wantPath string
+		wantLine string
+	}{
+		{"main.go", "main.go", ""},
+		{"main.go:42", "main.go", "42"},
+		{"main.go:42:7", "main.go", "42"},
+		{"main.go:42:7: undefined: x", "main.go", "42"},
+		{"main.go:42:	foo := bar:1", "main.go", "42"},
+		{"main.go:42-50", "main.go", "42-50"},
+		{"main.go:42-42", "main.go", "42"},
+		{"main.go#L42", "main.go", "42"},
+		{"main.go#L42-L50", "main.go", "42-50"},
+		{"main.go#L42-50", "main.go", "42-50"},
+		{"dir/x.go:3", "dir/x.go", "3"},
+
+		// Colons in real file names.
+		{"notes:2.txt", "notes:2.txt", ""},
+		{"notes:2.txt:9", "notes:2.txt", "9"},
+		{"a:1", "a:1", ""},
+		{"a:1:5", "a:1", "5"},
+
+		// Missing files still lose their line, for a clearer error.
+		{"new.go:12", "new.go", "12"},
+		{"new.go:12:3", "new.go", "12"},
+
+		// Not locations.
+		{"main.go:", "main.go:", ""},
+		{"main.go:x", "main.go:x", ""},
+		{"main.go#readme", "main.go#readme", ""},
+		{":42", ":42", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			path, line := splitLocation(tt.arg, exists)
+			if path != tt.wantPath || line != tt.wantLine {
+				t.Errorf("splitLocation(%q) = %q, %q, want %q, %q", tt.arg, path, line, tt.wantPath, tt.wantLine)
+			}
+		})
+	}
+}
+
 func TestParseArgs_Print(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runStdin is `gopen --stdin`: it reads path[:line] entries, one per line,
+// and prints the URL of each in input order, or copies them all at once with
+// -c. Nothing is opened in the browser; a review checklist can run to
+// thousands of entries.
+func runStdin(cfg config, r io.Reader) error {
+	pages, err := batchPages(cfg, r, printWarning)
+	if err != nil {
+		return err
+	}
+	if !cfg.copy {
+		cfg.print = true
+	}
+	return deliverPages(cfg, pages)
+}
+
+// batchPages builds the default command's page for every entry r holds, in
+// the order it holds them. Blank lines are skipped; an entry that names no
+// path, or a path outside any repository, stops the batch.
+func batchPages(cfg config, r io.Reader, warn func(string)) ([]page, error) {
+	b := newURLBuilder(cfg, warn)
+	var pages []page
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" {
+			continue
+		}
+		path, line := splitLocation(entry, pathExists)
+		pg, err := b.page(path, line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		pages = append(pages, pg)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading standard input: %w", err)
+	}
+	return pages, nil
+}
+
+// pathPages builds the default command's page for each path argument, in
+// order, or for the working directory when there is none. Errors name the path
+// once there is more than one.
+func pathPages(cfg config, warn func(string)) ([]page, error) {
+	paths := cfg.paths
+	if len(paths) == 0 {
+		paths = []string{""}
+	}
+	b := newURLBuilder(cfg, warn)
+	pages := make([]page, 0, len(paths))
+	for i, path := range paths {
+		pg, err := b.page(path, cfg.lineAt(i))
+		if err != nil {
+			if len(paths) > 1 {
+				err = fmt.Errorf("%s: %w", path, err)
+			}
+			return nil, err
+		}
+		pages = append(pages, pg)
+	}
+	return pages, nil
+}
+
+// urlBuilder builds the default command's URL for one path after another,
+// which may lie in any number of repositories, submodules included. Only the
+// path is read per call. The repository side, everything viewContext reads,
+// is read once per repository, and each of its warnings is passed to warn
+// once, however many paths share it.
+type urlBuilder struct {
+	cfg   config
+	warn  func(string)
+	idx   *repoIndex
+	repos map[string]builtRepo // by work-tree root
+}
+
+// builtRepo is what urlBuilder reads once per repository.
+type builtRepo struct {
+	ctx        repoContext // relPath is the root's, ""
+	commitHash string
+}
+
+func newURLBuilder(cfg config, warn func(string)) *urlBuilder {
+	return &urlBuilder{cfg: cfg, warn: warn, idx: newRepoIndex(), repos: make(map[string]builtRepo)}
+}
+
+// page returns the page of path, relative to the working directory and ""
+// for the directory itself, at line when it is not "".
+func (b *urlBuilder) page(path, line string) (page, error) {
+	var paths []string
+	if path != "" {
+		paths = []string{path}
+	}
+	targetPath, err := resolvePath(paths)
+	if err != nil {
+		return page{}, err
+	}
+	root, relPath, err := b.idx.locate(targetPath)
+	if err != nil {
+		return page{}, err
+	}
+
+	repo, ok := b.repos[root]
+	if !ok {
+		var warning string
+		repo.ctx, repo.commitHash, warning, err = viewContext(b.cfg, root)
+		if err != nil {
+			return page{}, err
+		}
+		if warning != "" {
+			b.warn(warning)
+		}
+		b.repos[root] = repo
+	}
+
+	ctx := repo.ctx
+	ctx.relPath = relPath
+	u, err := pageURL(b.cfg, ctx, targetPath, line, repo.commitHash)
+	if err != nil {
+		return page{}, err
+	}
+	return page{url: u, ctx: ctx, line: line, commitHash: repo.commitHash}, nil
+}
+
+// repoIndex maps directories to the work tree they belong to, so a batch of
+// paths costs one repository discovery per repository rather than per path.
+type repoIndex struct {
+	roots map[string]string // symlink-resolved directory → its work-tree root
+}
+
+func newRepoIndex() *repoIndex {
+	return &repoIndex{roots: make(map[string]string)}
+}
+
+// locate returns the work-tree root of the repository holding targetPath and
+// targetPath relative to it, as getRepoContext would report them.
+//
+// The walk up from the target is git's own: a directory with no .git entry
+// that is not a git directory itself belongs to whatever its parent belongs
+// to. So the walk stops at the first directory already indexed, and only a
+// directory where it would have found something of its own sends it to full
+// discovery. The environment variables that replace the walk disable that
+// shortcut; each directory is then discovered on its own.
+func (idx *repoIndex) locate(targetPath string) (root, relPath string, err error) {
+	dir, target, err := resolveTarget(targetPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	root, ok := idx.roots[dir]
+	if !ok {
+		var walked []string
+		if gitDiscoveryEnvOverride() == "" {
+			for d := dir; ; {
+				if root, ok = idx.roots[d]; ok || hasGitEntry(d) {
+					break
+				}
+				walked = append(walked, d)
+				parent := filepath.Dir(d)
+				if parent == d {
+					break
+				}
+				d = parent
+			}
+		}
+		if !ok {
+			if root, err = workTreeRoot(dir); err != nil {
+				return "", "", err
+			}
+		}
+		idx.roots[dir] = root
+		for _, d := range walked {
+			idx.roots[d] = root
+		}
+	}
+
+	relPath, err = relativeToRoot(root, target)
+	if err != nil {
+		return "", "", err
+	}
+	return root, relPath, nil
+}
+
+// hasGitEntry reports whether discovery would stop at dir: it holds a .git
+// entry, or is a git directory itself.
+func hasGitEntry(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		return true
+	}
+	return isGitDirItself(dir)
+}
+
+// workTreeRoot returns the root of the work tree holding dir, from .git when
+// discovery is certain of it and from git otherwise, the way getRepoContext
+// computes relPath.
+func workTreeRoot(dir string) (string, error) {
+	if layout, err := discoverRepoLayout(dir); err == nil {
+		return layout.workTree, nil
+	}
+	if !isGitRepo(dir) {
+		return "", errors.New("not in a git repository")
+	}
+	return getRepoRoot(dir)
+}
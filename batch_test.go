@@ -0,0 +1,147 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBatchURLs(t *testing.T) {
+	pinConfigScope(t)
+	dir := newTmpGitRepo(t)
+	runGit(t, dir, "remote", "add", "origin", "git@github.com:user/repo.git")
+	runGit(t, dir, "update-ref", "refs/remotes/origin/master", "HEAD")
+	mkdirAll(t, filepath.Join(dir, "src", "deep"))
+	writeFile(t, filepath.Join(dir, "main.go"), "package main\n")
+	writeFile(t, filepath.Join(dir, "src", "deep", "a.go"), "package deep\n")
+
+	// A second repository, nested in the first, gets its own context.
+	other := newTmpGitRepoIn(t, mkdirAll(t, filepath.Join(dir, "vendor", "other")))
+	runGit(t, other, "remote", "add", "origin", "https://gitlab.com/org/other.git")
+	writeFile(t, filepath.Join(other, "lib.go"), "package lib\n")
+	t.Chdir(dir)
+
+	input := strings.Join([]string{
+		"main.go:42:7: undefined: x",
+		"",
+		"src/deep/a.go",
+		filepath.Join(other, "lib.go") + "#L3-L5",
+		"src/deep",
+		"vendor/other/lib.go:9",
+	}, "\n")
+	var warnings []string
+	pages, err := batchPages(config{remoteName: "origin"}, strings.NewReader(input), func(w string) {
+		warnings = append(warnings, w)
+	})
+	if err != nil {
+		t.Fatalf("batchPages: %v", err)
+	}
+	want := []string{
+		"https://github.com/user/repo/tree/master/main.go#L42",
+		"https://github.com/user/repo/tree/master/src/deep/a.go",
+		"https://gitlab.com/org/other/-/tree/master/lib.go#L3-5",
+		"https://github.com/user/repo/tree/master/src/deep",
+		"https://gitlab.com/org/other/-/tree/master/lib.go#L9",
+	}
+	if got := urlsOf(pages); !reflect.DeepEqual(got, want) {
+		t.Errorf("batchPages:\n  got  %q\n  want %q", got, want)
+	}
+	// Only the nested repository's branch is unpushed, and it is said once.
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "not on origin") {
+		t.Errorf("warnings = %q, want one for the nested repository", warnings)
+	}
+
+	t.Run("a missing path stops the batch at its line", func(t *testing.T) {
+		_, err := batchPages(config{remoteName: "origin"}, strings.NewReader("main.go\ngone.go:3\n"), func(string) {})
+		if err == nil || !strings.Contains(err.Error(), "line 2") {
+			t.Errorf("batchPages error = %v, want one naming line 2", err)
+		}
+	})
+}
+
+func TestRepoIndexLocate(t *testing.T) {
+	pinConfigScope(t)
+	dir := newTmpGitRepo(t)
+	mkdirAll(t, filepath.Join(dir, "a", "b", "c"))
+	writeFile(t, filepath.Join(dir, "a", "b", "c", "f.txt"), "")
+	nested := newTmpGitRepoIn(t, mkdirAll(t, filepath.Join(dir, "a", "nested")))
+	mkdirAll(t, filepath.Join(nested, "x"))
+
+	idx := newRepoIndex()
+	for _, tt := range []struct {
+		path     string
+		wantRoot string
+		wantRel  string
+	}{
+		{filepath.Join(dir, "a", "b", "c", "f.txt"), dir, "a/b/c/f.txt"},
+		// Answered from the directories the first walk indexed.
+		{filepath.Join(dir, "a", "b"), dir, "a/b"},
+		// The walk must not run past the nested repository's .git.
+		{filepath.Join(nested, "x"), nested, "x"},
+		{filepath.Join(dir, "a"), dir, "a"},
+	} {
+		root, rel, err := idx.locate(tt.path)
+		if err != nil {
+			t.Fatalf("locate(%q): %v", tt.path, err)
+		}
+		wantRoot, _ := filepath.EvalSymlinks(tt.wantRoot)
+		if root != wantRoot || rel != filepath.FromSlash(tt.wantRel) {
+			t.Errorf("locate(%q) = %q, %q, want %q, %q", tt.path, root, rel, wantRoot, tt.wantRel)
+		}
+		// The root is the one git names.
+		gitDir := tt.path
+		if filepath.Ext(gitDir) != "" {
+			gitDir = filepath.Dir(gitDir)
+		}
+		if got := gitOut(t, gitDir, "rev-parse", "--show-toplevel"); root != filepath.FromSlash(got) {
+			t.Errorf("locate(%q) root = %q, git says %q", tt.path, root, got)
+		}
+	}
+}
+
+func TestPathURLs(t *testing.T) {
+	pinConfigScope(t)
+	super, sub := newTmpSubmodule(t)
+	runGit(t, super, "remote", "add", "origin", "git@github.com:user/super.git")
+	runGit(t, super, "update-ref", "refs/remotes/origin/master", "HEAD")
+	runGit(t, sub, "remote", "set-url", "origin", "https://gitlab.com/org/sub.git")
+	runGit(t, sub, "update-ref", "refs/remotes/origin/master", "HEAD")
+	writeFile(t, filepath.Join(super, "a.go"), "")
+	writeFile(t, filepath.Join(sub, "b.go"), "")
+	t.Chdir(super)
+
+	cfg, err := parseArgs([]string{"a.go", "sub/b.go:3", "a.go#L7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := pathPages(cfg, func(w string) { t.Errorf("unexpected warning %q", w) })
+	if err != nil {
+		t.Fatalf("pathPages: %v", err)
+	}
+	want := []string{
+		"https://github.com/user/super/tree/master/a.go",
+		"https://gitlab.com/org/sub/-/tree/master/b.go#L3",
+		"https://github.com/user/super/tree/master/a.go#L7",
+	}
+	if got := urlsOf(pages); !reflect.DeepEqual(got, want) {
+		t.Errorf("pathPages:\n  got  %q\n  want %q", got, want)
+	}
+
+	t.Run("no path is the working directory", func(t *testing.T) {
+		pages, err := pathPages(config{remoteName: "origin"}, func(string) {})
+		if err != nil {
+			t.Fatalf("pathPages: %v", err)
+		}
+		if got, want := urlsOf(pages), []string{"https://github.com/user/super/tree/master"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("pathPages = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("an error names the path among several", func(t *testing.T) {
+		_, err := pathPages(config{remoteName: "origin", paths: []string{"a.go", "gone.go"}}, func(string) {})
+		if err == nil || !strings.HasPrefix(err.Error(), "gone.go: ") {
+			t.Errorf("pathPages error = %v, want one naming gone.go", err)
+		}
+	})
+}
@@ -40,10 +40,20 @@ _gopen() {
         -r|--remote|-l|--line|--commit|--completion)
             return
             ;;
+        --format)
+            COMPREPLY=($(compgen -W "json markdown html org rst slack template=" -- "${cur}"))
+            return
+            ;;
+        --ref)
+            COMPREPLY=($(compgen -W "$(git for-each-ref --format='%(refname:short)' refs/heads refs/tags refs/remotes 2>/dev/null)" -- "${cur}"))
+            return
+            ;;
     esac
 
     if [[ "${cur}" == -* ]]; then
-        COMPREPLY=($(compgen -W "-v --version -c --copy -p --print -r --remote -l --line --commit --completion" -- "${cur}"))
+        COMPREPLY=($(compgen -W "-v --version -c --copy -p --print -r --remote --no-upstream --strict -l --line --commit --ref --permalink --blame --history --edit --stdin --format --completion" -- "${cur}"))
+    elif [[ ${COMP_CWORD} -eq 1 ]]; then
+        COMPREPLY=($(compgen -W "pr compare resolve" -- "${cur}") $(compgen -f -- "${cur}"))
     else
         COMPREPLY=($(compgen -f -- "${cur}"))
     fi
@@ -61,10 +71,20 @@ _gopen() {
         '(-v --version)'{-v,--version}'[Print version information]' \
         '(-c --copy)'{-c,--copy}'[Copy URL to clipboard instead of opening browser]' \
         '(-p --print)'{-p,--print}'[Print the URL to stdout and exit]' \
-        '(-r --remote)'{-r,--remote}'[Git remote to use (default: origin)]:remote name:' \
+        '(-r --remote)'{-r,--remote}'[Git remote to use (default: upstream remote, else origin)]:remote name:' \
+        '--no-upstream[Use the local branch name, not the upstream name]' \
+        '--strict[Fail when HEAD is not pushed to the remote]' \
         '(-l --line)'{-l,--line}'[Highlight line or range (e.g. 42 or 42-50)]:line:' \
         '--commit[Open a specific commit]:hash:' \
+        '--ref[Open at a branch, tag or remote-tracking branch]:ref:($(git for-each-ref --format="%(refname:short)" refs/heads refs/tags refs/remotes 2>/dev/null))' \
+        '--permalink[Pin the URL to the commit HEAD resolves to]' \
+        '--blame[Open the blame view of the file]' \
+        '--history[Open the commits that touched the path]' \
+        '--edit[resolve: open the file in the editor]' \
+        '--stdin[Read path\[:line\] entries from stdin, print a URL each]' \
+        '--format[Print each URL in a format]:format:(json markdown html org rst slack template=)' \
         '--completion[Output shell completion script]:shell:(bash zsh fish)' \
+        '1::command or path:_alternative "commands:command:(pr compare resolve)" "files:path:_files"' \
         '*:path:_files'
 }
 
@@ -78,8 +98,20 @@ const fishCompletion = `# gopen fish completion
 complete -c gopen -s v -l version -d 'Print version information' -f
 complete -c gopen -s c -l copy -d 'Copy URL to clipboard instead of opening browser' -f
 complete -c gopen -s p -l print -d 'Print the URL to stdout and exit' -f
-complete -c gopen -s r -l remote -d 'Git remote to use (default: origin)' -r
+complete -c gopen -s r -l remote -d 'Git remote to use (default: upstream remote, else origin)' -r
+complete -c gopen -l no-upstream -d 'Use the local branch name, not the upstream name' -f
+complete -c gopen -l strict -d 'Fail when HEAD is not pushed to the remote' -f
 complete -c gopen -s l -l line -d 'Highlight line or range (e.g. 42 or 42-50)' -r
 complete -c gopen -l commit -d 'Open a specific commit' -r -f
+complete -c gopen -l ref -d 'Open at a branch, tag or remote-tracking branch' -r -f -a '(git for-each-ref --format="%(refname:short)" refs/heads refs/tags refs/remotes 2>/dev/null)'
+complete -c gopen -l permalink -d 'Pin the URL to the commit HEAD resolves to' -f
+complete -c gopen -l blame -d 'Open the blame view of the file' -f
+complete -c gopen -l history -d 'Open the commits that touched the path' -f
+complete -c gopen -l edit -d 'resolve: open the file in the editor' -f
+complete -c gopen -l stdin -d 'Read path[:line] entries from stdin, print a URL each' -f
+complete -c gopen -l format -d 'Print each URL in a format' -r -f -a 'json markdown html org rst slack template='
 complete -c gopen -l completion -d 'Output shell completion script' -r -f -a 'bash zsh fish'
+complete -c gopen -n '__fish_use_subcommand' -a pr -d 'Open the pull/merge request for the current branch'
+complete -c gopen -n '__fish_use_subcommand' -a compare -d 'Compare the current branch against a base branch'
+complete -c gopen -n '__fish_use_subcommand' -a resolve -d 'Print the local path:line a forge URL points at'
 `
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// page is a URL gopen built and what it was built from, for the output
+// formats that report more than the URL.
+type page struct {
+	url        string
+	ctx        repoContext
+	line       string // as --line takes it, "" for none
+	commitHash string // the commit the URL is pinned to, "" when it names ctx's ref
+}
+
+// urlsOf returns the URL of each page.
+func urlsOf(pages []page) []string {
+	urls := make([]string, len(pages))
+	for i, pg := range pages {
+		urls[i] = pg.url
+	}
+	return urls
+}
+
+// formatNames are the values --format takes, besides template=<template>.
+var formatNames = []string{"json", "markdown", "html", "org", "rst", "slack"}
+
+// templatePrefix introduces a --format that is a text/template of its own.
+const templatePrefix = "template="
+
+// linkTemplates are the built-in link formats, each a text/template over a
+// pageInfo rendering one link.
+var linkTemplates = map[string]string{
+	"markdown": "[{{markdownCode .Label}}]({{markdownURL .URL}})",
+	"html":     `<a href="{{html .URL}}"><code>{{html .Label}}</code></a>`,
+	"org":      "[[{{orgURL .URL}}][={{orgVerbatim .Label}}=]]",
+	"rst":      "`{{rst .Label}} <{{rstURL .URL}}>`__",
+	"slack":    "<{{slackURL .URL}}|{{slack .Label}}>",
+}
+
+// templateFuncs are available to every format template, built in or not, on
+// top of text/template's own (html, urlquery, printf, ...).
+var templateFuncs = template.FuncMap{
+	"markdownCode": markdownCode,
+	"markdownURL":  markdownURL,
+	"orgVerbatim":  orgVerbatim,
+	"orgURL":       orgURL,
+	"rst":          rstEscape,
+	"rstURL":       rstURL,
+	"slack":        slackEscape,
+	"slackURL":     slackURL,
+}
+
+// checkFormat reports whether format is one --format takes, and for a
+// template whether it parses, so a typo fails before any git work is done.
+func checkFormat(format string) error {
+	if tmpl, ok := strings.CutPrefix(format, templatePrefix); ok {
+		_, err := parseFormatTemplate(tmpl)
+		return err
+	}
+	for _, name := range formatNames {
+		if format == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown --format %q (known: %s, %s<template>)", format, strings.Join(formatNames, ", "), templatePrefix)
+}
+
+func parseFormatTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("format").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("--format template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// formatPages renders pages in format, which checkFormat accepted. text is
+// the output itself. html, when not "", is the same links as HTML, for the
+// clipboard to offer rich-text editors alongside it; only the link formats
+// have one.
+func formatPages(format string, pages []page) (text, htmlText string, err error) {
+	if format == "json" {
+		text, err = formatJSON(pages)
+		return text, "", err
+	}
+
+	tmplText, isTemplate := strings.CutPrefix(format, templatePrefix)
+	if !isTemplate {
+		tmplText = linkTemplates[format]
+	}
+	if text, err = renderPages(tmplText, pages, "\n"); err != nil {
+		return "", "", err
+	}
+	if isTemplate {
+		return text, "", nil
+	}
+	if htmlText, err = renderPages(linkTemplates["html"], pages, "<br>\n"); err != nil {
+		return "", "", err
+	}
+	return text, htmlText, nil
+}
+
+// renderPages executes tmplText for each page and joins the results with sep,
+// ending the whole with a newline.
+func renderPages(tmplText string, pages []page, sep string) (string, error) {
+	tmpl, err := parseFormatTemplate(tmplText)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	for i, pg := range pages {
+		info, err := newPageInfo(pg)
+		if err != nil {
+			return "", err
+		}
+		if i > 0 {
+			buf.WriteString(sep)
+		}
+		if err := tmpl.Execute(&buf, info); err != nil {
+			return "", fmt.Errorf("--format template: %w", err)
+		}
+	}
+	buf.WriteString("\n")
+	return buf.String(), nil
+}
+
+// pageInfo is what the output formats know of a page: the object --format
+// json prints, and the data a format template is executed with. Its field
+// names are a contract with the editor plugins and scripts reading them, in
+// both spellings: fields may be added, never renamed or removed.
+type pageInfo struct {
+	URL       string     `json:"url"`
+	Provider  string     `json:"provider"`
+	Remote    string     `json:"remote"`
+	RemoteURL string     `json:"remote_url"` // as `git remote get-url` prints it
+	RepoURL   string     `json:"repo_url"`   // the remote URL normalized to the repository's web page
+	Ref       string     `json:"ref"`        // what the URL names: a branch, a tag or a commit id
+	RefType   string     `json:"ref_type"`   // "branch", "tag" or "commit"
+	Head      string     `json:"head"`       // the commit HEAD resolves to
+	Root      string     `json:"root"`
+	Path      string     `json:"path"` // relative to root, slash-separated; "" for the root
+	Lines     *linesJSON `json:"lines,omitempty"`
+	Reader    string     `json:"reader"` // "go" when .git was read directly, "git" when the git binary was asked
+	Label     string     `json:"label"`  // the link text the link formats use
+}
+
+// linesJSON is a page's line range; end equals start for a single line.
+type linesJSON struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// refTypeNames are pageInfo.RefType's values, by refKind.
+var refTypeNames = map[refKind]string{
+	refBranch: "branch",
+	refTag:    "tag",
+	refCommit: "commit",
+}
+
+func newPageInfo(pg page) (pageInfo, error) {
+	ref, kind := viewRef(pg.ctx, pg.commitHash)
+	info := pageInfo{
+		URL:       pg.url,
+		Provider:  providerFor(pg.ctx).name,
+		Remote:    pg.ctx.remote,
+		RemoteURL: pg.ctx.remoteURL,
+		RepoURL:   pg.ctx.baseURL,
+		Ref:       ref,
+		RefType:   refTypeNames[kind],
+		Head:      pg.ctx.commit,
+		Root:      pg.ctx.root,
+		Path:      filepath.ToSlash(pg.ctx.relPath),
+		Reader:    pg.ctx.reader,
+	}
+	if pg.line != "" {
+		lines, err := parseLines(pg.line)
+		if err != nil {
+			return pageInfo{}, err
+		}
+		info.Lines = &lines
+	}
+	info.Label = info.label()
+	return info, nil
+}
+
+// label names the page the way a reader of the link wants to see it:
+// pkg/foo.go#L42, or the repository's name for its root, with the commit for
+// a commit page.
+func (p pageInfo) label() string {
+	label := p.Path
+	if label == "" {
+		label = path.Base(p.RepoURL)
+		if p.RefType == "commit" {
+			label += "@" + shortCommit(p.Ref)
+		}
+	}
+	switch {
+	case p.Lines == nil:
+	case p.Lines.Start == p.Lines.End:
+		label += fmt.Sprintf("#L%d", p.Lines.Start)
+	default:
+		label += fmt.Sprintf("#L%d-L%d", p.Lines.Start, p.Lines.End)
+	}
+	return label
+}
+
+// shortCommit abbreviates a commit id to the seven characters forges show.
+func shortCommit(id string) string {
+	if len(id) > 7 {
+		return id[:7]
+	}
+	return id
+}
+
+// formatJSON renders each page as one JSON object on a line of its own, in
+// order, so a single page is a plain JSON document and many can be read as a
+// stream.
+func formatJSON(pages []page) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	// Nobody reading this embeds it in HTML, and & escaped to \u0026 in
+	// every query string would only get in their way.
+	enc.SetEscapeHTML(false)
+	for _, pg := range pages {
+		info, err := newPageInfo(pg)
+		if err != nil {
+			return "", err
+		}
+		if err := enc.Encode(info); err != nil {
+			return "", err
+		}
+	}
+	return buf.String(), nil
+}
+
+// parseLines reads a --line value into numbers, which the URL formats never
+// needed to do.
+func parseLines(line string) (linesJSON, error) {
+	start, end := splitLineRange(line)
+	if end == "" {
+		end = start
+	}
+	s, err := strconv.Atoi(start)
+	if err != nil {
+		return linesJSON{}, fmt.Errorf("line %q is not a line number or range", line)
+	}
+	e, err := strconv.Atoi(end)
+	if err != nil {
+		return linesJSON{}, fmt.Errorf("line %q is not a line number or range", line)
+	}
+	return linesJSON{Start: s, End: e}, nil
+}
+
+// slackEscape escapes the three characters Slack's mrkdwn reserves, as its
+// formatting reference asks: &, < and >.
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// slackURL percent-encodes what would end the URL of a Slack link early: the
+// angle brackets around it and the | before its text.
+func slackURL(u string) string {
+	return strings.NewReplacer("<", "%3C", ">", "%3E", "|", "%7C").Replace(u)
+}
+
+// markdownCode returns s as a Markdown code span. The fence is one backtick
+// longer than the longest run in s, and padded with a space when s starts or
+// ends with one, so no backtick in a path can close it early. Brackets need
+// nothing: a code span binds tighter than the link text around it.
+func markdownCode(s string) string {
+	longest, run := 0, 0
+	for _, r := range s {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	fence := strings.Repeat("`", longest+1)
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+		s = " " + s + " "
+	}
+	return fence + s + fence
+}
+
+// markdownURL percent-encodes what would end a Markdown link destination
+// early or make it invalid: spaces, parentheses and angle brackets.
+func markdownURL(u string) string {
+	return strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29", "<", "%3C", ">", "%3E").Replace(u)
+}
+
+// orgVerbatim makes s safe inside Org's =verbatim= link description. Org has
+// no escape character; its manual suggests a zero-width space instead, here
+// after each = that could close the markup and each ] that could close the
+// link.
+func orgVerbatim(s string) string {
+	return strings.NewReplacer("=", "=\u200b", "]", "]\u200b").Replace(s)
+}
+
+// orgURL percent-encodes the brackets that would end an Org link's target.
+func orgURL(u string) string {
+	return strings.NewReplacer("[", "%5B", "]", "%5D").Replace(u)
+}
+
+// rstEscape backslash-escapes what would end the text of an reStructuredText
+// hyperlink reference early, or be read as the start of its target.
+func rstEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "`", "\\`", "<", `\<`, ">", `\>`).Replace(s)
+}
+
+// rstURL percent-encodes what reStructuredText would drop from an embedded
+// target (whitespace) or read as its end.
+func rstURL(u string) string {
+	return strings.NewReplacer(" ", "%20", "<", "%3C", ">", "%3E", "`", "%60").Replace(u)
+}
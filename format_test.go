@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFormatJSON(t *testing.T) {
+	ctx := repoContext{
+		baseURL:   "https://gitlab.com/org/repo",
+		remote:    "upstream",
+		remoteURL: "git@gitlab.com:org/repo.git",
+		branch:    "main",
+		commit:    "0123456789abcdef0123456789abcdef01234567",
+		root:      "/src/repo",
+		relPath:   "pkg/app.go",
+		reader:    readerGo,
+	}
+	tag := ctx
+	tag.branch, tag.refKind, tag.relPath, tag.reader = "v1.2.0", refTag, "", readerGit
+	generic := ctx
+	generic.baseURL, generic.remoteURL = "https://git.corp.example/org/repo", "git@git.corp.example:org/repo.git"
+
+	got, err := formatJSON([]page{
+		{url: "https://gitlab.com/org/repo/-/tree/main/pkg/app.go?a=1&b=2#L4-8", ctx: ctx, line: "4-8"},
+		{url: "https://gitlab.com/org/repo/-/commit/abc1234", ctx: ctx, line: "9", commitHash: "abc1234"},
+		{url: "https://gitlab.com/org/repo/-/tree/v1.2.0", ctx: tag},
+		{url: "https://git.corp.example/org/repo/tree/main/pkg/app.go", ctx: generic},
+	})
+	if err != nil {
+		t.Fatalf("formatJSON: %v", err)
+	}
+	if strings.Contains(got, `\u0026`) {
+		t.Errorf("formatJSON escaped & in a URL:\n%s", got)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	want := []map[string]any{
+		{
+			"url": "https://gitlab.com/org/repo/-/tree/main/pkg/app.go?a=1&b=2#L4-8", "provider": "gitlab",
+			"remote": "upstream", "remote_url": "git@gitlab.com:org/repo.git", "repo_url": "https://gitlab.com/org/repo",
+			"ref": "main", "ref_type": "branch", "head": ctx.commit, "root": "/src/repo", "path": "pkg/app.go",
+			"lines": map[string]any{"start": 4.0, "end": 8.0}, "reader": "go", "label": "pkg/app.go#L4-L8",
+		},
+		{
+			"url": "https://gitlab.com/org/repo/-/commit/abc1234", "provider": "gitlab",
+			"remote": "upstream", "remote_url": "git@gitlab.com:org/repo.git", "repo_url": "https://gitlab.com/org/repo",
+			"ref": "abc1234", "ref_type": "commit", "head": ctx.commit, "root": "/src/repo", "path": "pkg/app.go",
+			"lines": map[string]any{"start": 9.0, "end": 9.0}, "reader": "go", "label": "pkg/app.go#L9",
+		},
+		{
+			"url": "https://gitlab.com/org/repo/-/tree/v1.2.0", "provider": "gitlab",
+			"remote": "upstream", "remote_url": "git@gitlab.com:org/repo.git", "repo_url": "https://gitlab.com/org/repo",
+			"ref": "v1.2.0", "ref_type": "tag", "head": ctx.commit, "root": "/src/repo", "path": "",
+			"reader": "git", "label": "repo",
+		},
+		{
+			"url": "https://git.corp.example/org/repo/tree/main/pkg/app.go", "provider": "generic",
+			"remote": "upstream", "remote_url": "git@git.corp.example:org/repo.git", "repo_url": "https://git.corp.example/org/repo",
+			"ref": "main", "ref_type": "branch", "head": ctx.commit, "root": "/src/repo", "path": "pkg/app.go",
+			"reader": "go", "label": "pkg/app.go",
+		},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("formatJSON printed %d lines, want one per page:\n%s", len(lines), got)
+	}
+	for i, line := range lines {
+		var obj map[string]any
+		if err := json.Unmarshal([]byte(line), &obj); err != nil {
+			t.Fatalf("line %d is not JSON: %v\n%s", i+1, err, line)
+		}
+		if !reflect.DeepEqual(obj, want[i]) {
+			t.Errorf("line %d:\n  got  %v\n  want %v", i+1, obj, want[i])
+		}
+	}
+
+	if _, err := formatJSON([]page{{ctx: ctx, line: "x"}}); err == nil {
+		t.Error("formatJSON with line \"x\": want an error")
+	}
+}
+
+func TestParseLines(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    linesJSON
+		wantErr bool
+	}{
+		{line: "42", want: linesJSON{42, 42}},
+		{line: "42-50", want: linesJSON{42, 50}},
+		{line: "abc", wantErr: true},
+		{line: "42-x", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseLines(tt.line)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseLines(%q) = %+v, %v, want %+v, wantErr %v", tt.line, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestFormatPages(t *testing.T) {
+	ctx := repoContext{
+		baseURL: "https://github.com/user/repo",
+		remote:  "origin",
+		branch:  "main",
+		commit:  "0123456789abcdef0123456789abcdef01234567",
+		relPath: "pkg/foo.go",
+	}
+	file := page{url: "https://github.com/user/repo/tree/main/pkg/foo.go#L42", ctx: ctx, line: "42"}
+	root := ctx
+	root.relPath = ""
+	commit := page{url: "https://github.com/user/repo/commit/abc1234def", ctx: root, commitHash: "abc1234def"}
+	fileHTML := `<a href="https://github.com/user/repo/tree/main/pkg/foo.go#L42"><code>pkg/foo.go#L42</code></a>`
+	// A path with every character that can end a link early.
+	odd := page{url: "https://github.com/user/repo/tree/main/a b (1)`x`].go#L3", ctx: ctx, line: "3"}
+	odd.ctx.relPath = "a b (1)`x`].go"
+	oddHTML := "<a href=\"https://github.com/user/repo/tree/main/a b (1)`x`].go#L3\"><code>a b (1)`x`].go#L3</code></a>\n"
+	angled := page{url: "https://github.com/user/repo/tree/main/a<b>.go", ctx: ctx}
+	angled.ctx.relPath = "`a<b>.go"
+	angledHTML := "<a href=\"https://github.com/user/repo/tree/main/a&lt;b&gt;.go\"><code>`a&lt;b&gt;.go</code></a>\n"
+
+	tests := []struct {
+		format   string
+		pages    []page
+		want     string
+		wantHTML string
+	}{
+		{"markdown", []page{file}, "[`pkg/foo.go#L42`](https://github.com/user/repo/tree/main/pkg/foo.go#L42)\n", fileHTML + "\n"},
+		{"html", []page{file}, fileHTML + "\n", fileHTML + "\n"},
+		{"org", []page{file}, "[[https://github.com/user/repo/tree/main/pkg/foo.go#L42][=pkg/foo.go#L42=]]\n", fileHTML + "\n"},
+		{"rst", []page{file}, "`pkg/foo.go#L42 <https://github.com/user/repo/tree/main/pkg/foo.go#L42>`__\n", fileHTML + "\n"},
+		{"slack", []page{file}, "<https://github.com/user/repo/tree/main/pkg/foo.go#L42|pkg/foo.go#L42>\n", fileHTML + "\n"},
+		{
+			"markdown", []page{file, commit},
+			"[`pkg/foo.go#L42`](https://github.com/user/repo/tree/main/pkg/foo.go#L42)\n[`repo@abc1234`](https://github.com/user/repo/commit/abc1234def)\n",
+			fileHTML + "<br>\n" + `<a href="https://github.com/user/repo/commit/abc1234def"><code>repo@abc1234</code></a>` + "\n",
+		},
+		{"markdown", []page{odd}, "[``a b (1)`x`].go#L3``](https://github.com/user/repo/tree/main/a%20b%20%281%29`x`].go#L3)\n", oddHTML},
+		{"markdown", []page{angled}, "[`` `a<b>.go ``](https://github.com/user/repo/tree/main/a%3Cb%3E.go)\n", angledHTML},
+		{"rst", []page{odd}, "`a b (1)\\`x\\`].go#L3 <https://github.com/user/repo/tree/main/a%20b%20(1)%60x%60].go#L3>`__\n", oddHTML},
+		{"rst", []page{angled}, "`\\`a\\<b\\>.go <https://github.com/user/repo/tree/main/a%3Cb%3E.go>`__\n", angledHTML},
+		{"org", []page{odd}, "[[https://github.com/user/repo/tree/main/a b (1)`x`%5D.go#L3][=a b (1)`x`]\u200b.go#L3=]]\n", oddHTML},
+		{"slack", []page{angled}, "<https://github.com/user/repo/tree/main/a%3Cb%3E.go|`a&lt;b&gt;.go>\n", angledHTML},
+		{
+			"template={{.Path}}:{{with .Lines}}{{.Start}}{{end}} {{.RefType}} {{.URL}}", []page{file},
+			"pkg/foo.go:42 branch https://github.com/user/repo/tree/main/pkg/foo.go#L42\n", "",
+		},
+		{"template={{slack .URL}}", []page{{url: "https://x/?a=1&b=<2>", ctx: ctx}}, "https://x/?a=1&amp;b=&lt;2&gt;\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			text, html, err := formatPages(tt.format, tt.pages)
+			if err != nil {
+				t.Fatalf("formatPages: %v", err)
+			}
+			if text != tt.want {
+				t.Errorf("text:\n  got  %q\n  want %q", text, tt.want)
+			}
+			if html != tt.wantHTML {
+				t.Errorf("html:\n  got  %q\n  want %q", html, tt.wantHTML)
+			}
+		})
+	}
+
+	t.Run("html escapes", func(t *testing.T) {
+		odd := page{url: `https://x/a"b&c`, ctx: ctx}
+		odd.ctx.relPath = "a<b>.go"
+		text, _, err := formatPages("html", []page{odd})
+		if err != nil || text != `<a href="https://x/a&#34;b&amp;c"><code>a&lt;b&gt;.go</code></a>`+"\n" {
+			t.Errorf("formatPages(html) = %q, %v", text, err)
+		}
+	})
+	t.Run("template with an unknown field", func(t *testing.T) {
+		if _, _, err := formatPages("template={{.Nope}}", []page{file}); err == nil {
+			t.Error("formatPages: want an error")
+		}
+	})
+}
+
+func TestCheckFormat(t *testing.T) {
+	for format, wantErr := range map[string]bool{
+		"json":                   false,
+		"markdown":               false,
+		"slack":                  false,
+		"template={{.URL}}":      false,
+		"template=":              false,
+		"template={{.URL":        true,
+		"template={{nope .URL}}": true,
+		"md":                     true,
+		"":                       true,
+	} {
+		if err := checkFormat(format); (err != nil) != wantErr {
+			t.Errorf("checkFormat(%q) = %v, wantErr %v", format, err, wantErr)
+		}
+	}
+}
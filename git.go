@@ -6,16 +6,30 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 )
 
 // repoContext holds all git information needed to build a web URL.
 type repoContext struct {
-	baseURL string // HTTPS URL of the remote
-	branch  string
-	relPath string // relative path from repo root; empty = repo root
+	baseURL   string  // HTTPS URL of the remote
+	forge     string  // provider bound to the remote's host by gopen.<host>.type; "" = detect from the URL
+	remote    string  // name of the remote baseURL comes from
+	remoteURL string  // the remote's URL as `git remote get-url` prints it, insteadOf rewrites applied
+	branch    string  // checked-out branch, or the branch or tag --ref names
+	refKind   refKind // what branch names: refBranch, or refTag for a --ref tag
+	commit    string  // full object id HEAD resolves to, for --permalink
+	root      string  // work-tree root, symlink-resolved
+	relPath   string  // relative path from repo root; empty = repo root
+	reader    string  // what produced it: readerGo for .git read directly, readerGit for the git binary
 }
 
+const (
+	readerGo  = "go"
+	readerGit = "git"
+)
+
 // effectiveCwd returns the working directory, applying GIT_PREFIX when
 // gopen is invoked via a git alias (git changes cwd to repo root).
 func effectiveCwd() (string, error) {
@@ -57,7 +71,7 @@ func resolvePath(paths []string) (string, error) {
 
 // getRepoContext collects all git information needed to build the web URL.
 //
-// It prefers reading .git directly, which avoids four subprocess forks, and
+// It prefers reading .git directly, which avoids six subprocess forks, and
 // defers to the git binary whenever the fast path cannot be certain of the
 // result. Correctness always wins over speed: the fast path must never return
 // a value that differs from what git would have produced, so every state it
@@ -69,7 +83,7 @@ func getRepoContext(targetPath, remoteName string) (repoContext, error) {
 	return repoContextViaGit(targetPath, remoteName)
 }
 
-// repoContextViaGit is the subprocess fallback: four git invocations.
+// repoContextViaGit is the subprocess fallback: six git invocations.
 func repoContextViaGit(targetPath, remoteName string) (repoContext, error) {
 	// Same resolution the fast path applies, from the same helper so the two
 	// cannot drift: git reports a symlink-resolved root, so the target has to
@@ -94,6 +108,11 @@ func repoContextViaGit(targetPath, remoteName string) (repoContext, error) {
 		return repoContext{}, err
 	}
 
+	commit, err := getHeadCommit(dir)
+	if err != nil {
+		return repoContext{}, err
+	}
+
 	repoRoot, err := getRepoRoot(dir)
 	if err != nil {
 		return repoContext{}, err
@@ -104,13 +123,74 @@ func repoContextViaGit(targetPath, remoteName string) (repoContext, error) {
 		return repoContext{}, err
 	}
 
+	hostTypes, err := getHostTypes(dir)
+	if err != nil {
+		return repoContext{}, err
+	}
+	baseURL, forge, err := remoteWebURL(remoteURL, hostTypes)
+	if err != nil {
+		return repoContext{}, err
+	}
+
 	return repoContext{
-		baseURL: convertToHTTPS(remoteURL),
-		branch:  branch,
-		relPath: relPath,
+		baseURL:   baseURL,
+		forge:     forge,
+		remote:    remoteName,
+		remoteURL: remoteURL,
+		branch:    branch,
+		commit:    commit,
+		root:      repoRoot,
+		relPath:   relPath,
+		reader:    readerGit,
 	}, nil
 }
 
+// getHostTypes returns every gopen.<host>.type in scope, keyed by host. git
+// lists them in the order it reads them, so a later scope's value overrides an
+// earlier one here as it would for any single-valued key.
+func getHostTypes(dir string) (map[string]string, error) {
+	cmd := exec.Command("git", "config", "--get-regexp", `^gopen\..*\.type$`)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		// Exit status 1 means no key matched, which is the common case.
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read gopen.<host>.type: %w", err)
+	}
+
+	hostTypes := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimRight(string(output), "\n"), "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		if host, ok := hostTypeHost(key); ok {
+			hostTypes[host] = value
+		}
+	}
+	return hostTypes, nil
+}
+
+// getMaxTabs returns gopen.maxTabs as git sees it from the working directory,
+// or defaultMaxTabs when it is not set.
+func getMaxTabs() (int, error) {
+	cwd, err := effectiveCwd()
+	if err != nil {
+		return 0, err
+	}
+	cmd := exec.Command("git", "config", "--type=int", "--get", "gopen.maxtabs")
+	cmd.Dir = cwd
+	output, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return defaultMaxTabs, nil
+		}
+		return 0, fmt.Errorf("failed to read gopen.maxTabs: %w", err)
+	}
+	return strconv.Atoi(strings.TrimSpace(string(output)))
+}
+
 func isGitRepo(dir string) bool {
 	cmd := exec.Command("git", "rev-parse", "--git-dir")
 	cmd.Dir = dir
@@ -137,6 +217,16 @@ func getCurrentBranch(dir string) (string, error) {
 	return strings.TrimSpace(string(output)), nil
 }
 
+func getHeadCommit(dir string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
 func getRepoRoot(dir string) (string, error) {
 	cmd := exec.Command("git", "rev-parse", "--show-toplevel")
 	cmd.Dir = dir
@@ -146,3 +236,190 @@ func getRepoRoot(dir string) (string, error) {
 	}
 	return strings.TrimSpace(string(output)), nil
 }
+
+// getRemoteHEAD returns the default branch of remoteName, as recorded by
+// refs/remotes/<remote>/HEAD. Like getRepoContext it reads .git first and
+// defers to git when it cannot be sure.
+func getRemoteHEAD(targetPath, remoteName string) (string, error) {
+	if branch, err := readRemoteHEADFromDisk(targetPath, remoteName); err == nil {
+		return branch, nil
+	}
+
+	dir, _, err := resolveTarget(targetPath)
+	if err != nil {
+		return "", err
+	}
+	ref := "refs/remotes/" + remoteName + "/HEAD"
+	cmd := exec.Command("git", "symbolic-ref", "--quiet", ref)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s is not set; run `git remote set-head %s --auto` or name the base branch", ref, remoteName)
+	}
+	target := strings.TrimSpace(string(output))
+	branch, ok := strings.CutPrefix(target, "refs/remotes/"+remoteName+"/")
+	if !ok {
+		return "", fmt.Errorf("%s points at %q, outside the remote's namespace", ref, target)
+	}
+	return branch, nil
+}
+
+// getRef resolves a --ref name the way git does and returns what the forge
+// knows it by: a branch or tag name, and which of the two it is. A
+// remote-tracking branch is the branch on its remote, so origin/feature opens
+// feature on origin; remote is that remote's name, and "" for a local branch
+// or tag. Like getRepoContext it reads .git first and defers to git when it
+// cannot be sure.
+func getRef(targetPath, name string) (ref string, kind refKind, remote string, err error) {
+	full, err := readRefFromDisk(targetPath, name)
+	if err != nil {
+		if full, err = refViaGit(targetPath, name); err != nil {
+			return "", refBranch, "", err
+		}
+	}
+	if branch, ok := strings.CutPrefix(full, headRefPrefix); ok {
+		return branch, refBranch, "", nil
+	}
+	if tag, ok := strings.CutPrefix(full, "refs/tags/"); ok {
+		return tag, refTag, "", nil
+	}
+	if rest, ok := strings.CutPrefix(full, "refs/remotes/"); ok {
+		if remote, branch, ok := strings.Cut(rest, "/"); ok && branch != detachedHEAD {
+			return branch, refBranch, remote, nil
+		}
+	}
+	return "", refBranch, "", fmt.Errorf("%q is %s, which is not a branch or tag", name, full)
+}
+
+// refViaGit is getRef's fallback. For a commit id, or a name that matches
+// more than one ref, git prints nothing.
+func refViaGit(targetPath, name string) (string, error) {
+	dir, _, err := resolveTarget(targetPath)
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", "--symbolic-full-name", "--end-of-options", name)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	full := strings.TrimSpace(string(output))
+	if err != nil || full == "" {
+		return "", fmt.Errorf("%q is not a single branch or tag (if it names both, write refs/heads/%[1]s or refs/tags/%[1]s)", name)
+	}
+	return full, nil
+}
+
+// getUpstream returns the remote branch tracks and the branch's name there,
+// or two empty strings when it tracks nothing on a remote. Like
+// getRepoContext it reads .git first and defers to git when it cannot be sure.
+func getUpstream(targetPath, branch string) (remote, remoteBranch string, err error) {
+	if remote, remoteBranch, err := readUpstreamFromDisk(targetPath, branch); err == nil {
+		return remote, remoteBranch, nil
+	}
+
+	dir, _, err := resolveTarget(targetPath)
+	if err != nil {
+		return "", "", err
+	}
+	cmd := exec.Command("git", "for-each-ref", "--format=%(upstream:remotename) %(upstream:remoteref)", headRefPrefix+branch)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get upstream of %q: %w", branch, err)
+	}
+	// A remote name cannot contain a space, so the first one splits the two.
+	remote, merge, _ := strings.Cut(strings.TrimRight(string(output), "\n"), " ")
+	remoteBranch, ok := strings.CutPrefix(merge, headRefPrefix)
+	if remote == "" || remote == "." || !ok {
+		return "", "", nil
+	}
+	return remote, remoteBranch, nil
+}
+
+// getTrackingCommit returns the commit refs/remotes/<remote>/<branch> holds,
+// and whether that remote-tracking branch exists at all. Like getRepoContext
+// it reads .git first and defers to git when it cannot be sure.
+func getTrackingCommit(targetPath, remote, branch string) (string, bool, error) {
+	if oid, found, err := readTrackingCommitFromDisk(targetPath, remote, branch); err == nil {
+		return oid, found, nil
+	}
+
+	dir, _, err := resolveTarget(targetPath)
+	if err != nil {
+		return "", false, err
+	}
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", "--end-of-options", "refs/remotes/"+remote+"/"+branch)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		// Exit status 1 is --verify --quiet's "no such ref".
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("failed to read %s/%s: %w", remote, branch, err)
+	}
+	return strings.TrimSpace(string(output)), true, nil
+}
+
+// getMergeBase returns the best common ancestor of two commits, or "" when
+// their histories are unrelated. Walking history needs the object store, which
+// only git reads.
+func getMergeBase(targetPath, a, b string) (string, error) {
+	dir, _, err := resolveTarget(targetPath)
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command("git", "merge-base", "--end-of-options", a, b)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to find the merge base of %s and %s: %w", a, b, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// getRemoteNames lists the repository's remotes, origin first so that it wins
+// when two remotes share a URL. Only resolve needs every remote, so this goes
+// straight to git.
+func getRemoteNames(targetPath string) ([]string, error) {
+	dir, _, err := resolveTarget(targetPath)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("git", "remote")
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remotes: %w", err)
+	}
+	names := strings.Fields(string(output))
+	if i := slices.Index(names, "origin"); i > 0 {
+		names = slices.Insert(slices.Delete(names, i, i+1), 0, "origin")
+	}
+	return names, nil
+}
+
+// getPushRemote returns the remote `git push` sends branch to:
+// branch.<name>.pushRemote, then remote.pushDefault, then branch.<name>.remote,
+// then origin. git's own %(push:remotename) applies that precedence, so the
+// rules are not duplicated here.
+func getPushRemote(targetPath, branch string) (string, error) {
+	dir, _, err := resolveTarget(targetPath)
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command("git", "for-each-ref", "--format=%(push:remotename)", headRefPrefix+branch)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get push remote for %q: %w", branch, err)
+	}
+	if remote := strings.TrimSpace(string(output)); remote != "" {
+		return remote, nil
+	}
+	return "origin", nil
+}
@@ -247,6 +247,237 @@ func TestGetGitRemoteURL_Error(t *testing.T) {
 	}
 }
 
+func TestGetRemoteHEAD(t *testing.T) {
+	t.Run("symref set by clone or set-head", func(t *testing.T) {
+		dir := newTmpGitRepo(t)
+		runGit(t, dir, "symbolic-ref", "refs/remotes/origin/HEAD", "refs/remotes/origin/develop")
+		got, err := getRemoteHEAD(dir, "origin")
+		if err != nil || got != "develop" {
+			t.Errorf("getRemoteHEAD() = (%q, %v), want (%q, nil)", got, err, "develop")
+		}
+	})
+
+	t.Run("unset errors", func(t *testing.T) {
+		dir := newTmpGitRepo(t)
+		if got, err := getRemoteHEAD(dir, "origin"); err == nil {
+			t.Errorf("getRemoteHEAD() = %q, want an error when refs/remotes/origin/HEAD is missing", got)
+		}
+	})
+}
+
+func TestGetRemoteNames(t *testing.T) {
+	dir := newTmpGitRepo(t)
+	for _, name := range []string{"fork", "origin", "upstream"} {
+		runGit(t, dir, "remote", "add", name, "https://github.com/"+name+"/repo")
+	}
+	got, err := getRemoteNames(dir)
+	if err != nil || strings.Join(got, " ") != "origin fork upstream" {
+		t.Errorf("getRemoteNames() = (%q, %v), want origin first, then the rest in git's order", got, err)
+	}
+}
+
+func TestGetMaxTabs(t *testing.T) {
+	pinConfigScope(t)
+	dir := newTmpGitRepo(t)
+	t.Chdir(dir)
+	t.Setenv("GIT_PREFIX", "")
+
+	if got, err := getMaxTabs(); err != nil || got != defaultMaxTabs {
+		t.Errorf("getMaxTabs() unset = (%d, %v), want (%d, nil)", got, err, defaultMaxTabs)
+	}
+	runGit(t, dir, "config", "gopen.maxTabs", "25")
+	if got, err := getMaxTabs(); err != nil || got != 25 {
+		t.Errorf("getMaxTabs() = (%d, %v), want (25, nil)", got, err)
+	}
+	runGit(t, dir, "config", "gopen.maxTabs", "many")
+	if _, err := getMaxTabs(); err == nil {
+		t.Error("getMaxTabs() with a non-number: want an error")
+	}
+}
+
+func TestGetPushRemote(t *testing.T) {
+	tests := []struct {
+		name   string
+		config [][2]string
+		want   string
+	}{
+		{name: "nothing configured", want: "origin"},
+		{name: "branch remote", config: [][2]string{{"branch.main.remote", "upstream"}}, want: "upstream"},
+		{name: "pushDefault beats branch remote", config: [][2]string{{"branch.main.remote", "upstream"}, {"remote.pushDefault", "fork"}}, want: "fork"},
+		{name: "branch pushRemote beats pushDefault", config: [][2]string{{"remote.pushDefault", "fork"}, {"branch.main.pushRemote", "mine"}}, want: "mine"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newTmpGitRepo(t)
+			runGit(t, dir, "checkout", "-q", "-B", "main")
+			for _, kv := range tt.config {
+				runGit(t, dir, "config", kv[0], kv[1])
+			}
+			got, err := getPushRemote(dir, "main")
+			if err != nil || got != tt.want {
+				t.Errorf("getPushRemote() = (%q, %v), want (%q, nil)", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetRef(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      [][]string
+		ref        string
+		want       string
+		wantKind   refKind
+		wantRemote string
+		wantErr    bool
+	}{
+		{name: "branch", setup: [][]string{{"branch", "feature/x"}}, ref: "feature/x", want: "feature/x", wantKind: refBranch},
+		{name: "lightweight tag", setup: [][]string{{"tag", "v1.0"}}, ref: "v1.0", want: "v1.0", wantKind: refTag},
+		{name: "annotated tag", setup: [][]string{{"tag", "-a", "v2.0", "-m", "v2.0"}}, ref: "v2.0", want: "v2.0", wantKind: refTag},
+		{name: "packed tag", setup: [][]string{{"tag", "v1.0"}, {"pack-refs", "--all"}}, ref: "v1.0", want: "v1.0", wantKind: refTag},
+		{name: "full tag name", setup: [][]string{{"tag", "v1.0"}}, ref: "refs/tags/v1.0", want: "v1.0", wantKind: refTag},
+		{name: "remote-tracking branch", setup: [][]string{{"update-ref", "refs/remotes/origin/release/2.x", "HEAD"}}, ref: "origin/release/2.x", want: "release/2.x", wantKind: refBranch, wantRemote: "origin"},
+		{
+			name:       "remote name is its HEAD",
+			setup:      [][]string{{"update-ref", "refs/remotes/origin/develop", "HEAD"}, {"symbolic-ref", "refs/remotes/origin/HEAD", "refs/remotes/origin/develop"}},
+			ref:        "origin",
+			want:       "develop",
+			wantKind:   refBranch,
+			wantRemote: "origin",
+		},
+		{name: "tag beside branches under the same prefix", setup: [][]string{{"branch", "release/1.0"}, {"tag", "release"}}, ref: "release", want: "release", wantKind: refTag},
+		{name: "branch and tag of the same name", setup: [][]string{{"branch", "dup"}, {"tag", "dup"}}, ref: "dup", wantErr: true},
+		{name: "missing", ref: "no-such-ref", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newTmpGitRepo(t)
+			for _, args := range tt.setup {
+				runGit(t, dir, args...)
+			}
+			got, kind, remote, err := getRef(dir, tt.ref)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("getRef(%q) = (%q, %v, %q), want an error", tt.ref, got, kind, remote)
+				}
+				return
+			}
+			if err != nil || got != tt.want || kind != tt.wantKind || remote != tt.wantRemote {
+				t.Errorf("getRef(%q) = (%q, %v, %q, %v), want (%q, %v, %q, nil)", tt.ref, got, kind, remote, err, tt.want, tt.wantKind, tt.wantRemote)
+			}
+		})
+	}
+
+	t.Run("commit id is not a ref", func(t *testing.T) {
+		dir := newTmpGitRepo(t)
+		if got, _, _, err := getRef(dir, gitOut(t, dir, "rev-parse", "HEAD")); err == nil {
+			t.Errorf("getRef(<commit>) = %q, want an error", got)
+		}
+	})
+}
+
+func TestGetUpstream(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      [][]string
+		wantRemote string
+		wantBranch string
+	}{
+		{
+			name: "remote-tracking upstream",
+			setup: [][]string{
+				{"update-ref", "refs/remotes/origin/jsmith/feature", "HEAD"},
+				{"branch", "--set-upstream-to=origin/jsmith/feature"},
+			},
+			wantRemote: "origin",
+			wantBranch: "jsmith/feature",
+		},
+		{
+			// The fast path refuses a non-default refspec and defers to git.
+			name: "custom fetch refspec",
+			setup: [][]string{
+				{"config", "remote.origin.fetch", "+refs/heads/*:refs/remotes/mirror/*"},
+				{"update-ref", "refs/remotes/mirror/feature", "HEAD"},
+				{"config", "branch.feature.remote", "origin"},
+				{"config", "branch.feature.merge", "refs/heads/feature"},
+			},
+			wantRemote: "origin",
+			wantBranch: "feature",
+		},
+		{name: "no upstream"},
+		{name: "local upstream", setup: [][]string{{"branch", "base"}, {"branch", "--set-upstream-to=base"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newTmpGitRepo(t)
+			runGit(t, dir, "checkout", "-q", "-b", "feature")
+			runGit(t, dir, "remote", "add", "origin", "https://github.com/user/repo")
+			for _, args := range tt.setup {
+				runGit(t, dir, args...)
+			}
+			remote, branch, err := getUpstream(dir, "feature")
+			if err != nil || remote != tt.wantRemote || branch != tt.wantBranch {
+				t.Errorf("getUpstream() = (%q, %q, %v), want (%q, %q, nil)", remote, branch, err, tt.wantRemote, tt.wantBranch)
+			}
+		})
+	}
+}
+
+func TestGetTrackingCommit(t *testing.T) {
+	dir := newTmpGitRepo(t)
+	head := gitOut(t, dir, "rev-parse", "HEAD")
+	runGit(t, dir, "update-ref", "refs/remotes/origin/feature", "HEAD")
+	// A symref sends the fast path to git, which resolves it.
+	runGit(t, dir, "symbolic-ref", "refs/remotes/origin/alias", "refs/remotes/origin/feature")
+
+	tests := []struct {
+		branch    string
+		want      string
+		wantFound bool
+	}{
+		{branch: "feature", want: head, wantFound: true},
+		{branch: "alias", want: head, wantFound: true},
+		{branch: "missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.branch, func(t *testing.T) {
+			got, found, err := getTrackingCommit(dir, "origin", tt.branch)
+			if err != nil || got != tt.want || found != tt.wantFound {
+				t.Errorf("getTrackingCommit(%q) = (%q, %v, %v), want (%q, %v, nil)", tt.branch, got, found, err, tt.want, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestGetMergeBase(t *testing.T) {
+	dir := newTmpGitRepo(t)
+	base := gitOut(t, dir, "rev-parse", "HEAD")
+	runGit(t, dir, "checkout", "-q", "-b", "one")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "one")
+	runGit(t, dir, "checkout", "-q", "-b", "two", base)
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "two")
+	runGit(t, dir, "checkout", "-q", "--orphan", "unrelated")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "unrelated")
+
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{name: "diverged", a: "one", b: "two", want: base},
+		{name: "ancestor", a: "one", b: base, want: base},
+		{name: "unrelated histories", a: "one", b: "unrelated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getMergeBase(dir, tt.a, tt.b)
+			if err != nil || got != tt.want {
+				t.Errorf("getMergeBase(%q, %q) = (%q, %v), want (%q, nil)", tt.a, tt.b, got, err, tt.want)
+			}
+		})
+	}
+}
+
 // --- getRepoContext ---
 
 // realPath resolves symlinks — needed on macOS where t.TempDir() returns
@@ -336,4 +567,20 @@ func TestGetRepoContext(t *testing.T) {
 			t.Error("expected error for nonexistent remote, got nil")
 		}
 	})
+
+	t.Run("detached HEAD carries its commit, from both paths", func(t *testing.T) {
+		dir := newTmpGitRepo(t)
+		runGit(t, dir, "remote", "add", "origin", remoteURL)
+		runGit(t, dir, "checkout", "-q", "--detach", "HEAD")
+		want := gitOut(t, dir, "rev-parse", "HEAD")
+		for name, get := range map[string]func(string, string) (repoContext, error){
+			"getRepoContext":    getRepoContext,
+			"repoContextViaGit": repoContextViaGit,
+		} {
+			ctx, err := get(dir, "origin")
+			if err != nil || ctx.branch != detachedHEAD || ctx.commit != want {
+				t.Errorf("%s() = (branch %q, commit %q, %v), want (%q, %q, nil)", name, ctx.branch, ctx.commit, err, detachedHEAD, want)
+			}
+		}
+	})
 }
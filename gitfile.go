@@ -2,8 +2,12 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"os/exec"
@@ -246,21 +250,32 @@ const (
 	detachedHEAD = "HEAD"
 )
 
-// branchIsBorn reports whether refs/heads/<branch> actually resolves. Both the
-// loose ref and packed-refs live in the common dir, shared by every linked
-// worktree.
+// refOID returns the object id a ref resolves to, reading the loose ref file
+// first and packed-refs second, the order git consults them in. Both live in
+// the common dir, shared by every linked worktree.
 //
-// A false negative only costs a fallback to the git binary, so every read error
-// answers "not born" rather than guessing.
-func branchIsBorn(commonDir, branch string) bool {
-	ref := headRefPrefix + branch
-	if info, err := os.Lstat(filepath.Join(commonDir, filepath.FromSlash(ref))); err == nil && info.Mode().IsRegular() && info.Size() > 0 {
-		return true
+// A loose ref that exists but does not hold an object id — a symref, or a
+// corrupt file — shadows the packed entry in git too, so it answers "not found"
+// rather than falling through. A false negative only costs a fallback to the
+// git binary, so every read error does the same instead of guessing.
+func refOID(commonDir, ref string) (string, bool) {
+	path := filepath.Join(commonDir, filepath.FromSlash(ref))
+	if info, err := os.Lstat(path); err == nil && info.Mode().IsRegular() {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return "", false
+		}
+		oid := strings.TrimSpace(string(raw))
+		if !isHexSHA(oid) {
+			return "", false
+		}
+		// git accepts either case on read but always prints lowercase.
+		return strings.ToLower(oid), true
 	}
 
 	f, err := os.Open(filepath.Join(commonDir, "packed-refs"))
 	if err != nil {
-		return false
+		return "", false
 	}
 	defer func() { _ = f.Close() }()
 
@@ -271,17 +286,334 @@ func branchIsBorn(commonDir, branch string) bool {
 		if line == "" || line[0] == '#' || line[0] == '^' {
 			continue
 		}
-		if _, name, ok := strings.Cut(line, " "); ok && name == ref {
-			return true
+		if oid, name, ok := strings.Cut(line, " "); ok && name == ref {
+			if !isHexSHA(oid) {
+				return "", false
+			}
+			return strings.ToLower(oid), true
 		}
 	}
-	return false
+	return "", false
+}
+
+// headCommit returns the commit branch, the one HEAD names, resolves to: what
+// `git rev-parse HEAD` prints. A detached HEAD holds its commit itself, which
+// branchFromHEAD has already read.
+//
+// Unborn branch: HEAD names a branch that has no commit yet, right after
+// `git init` or `git checkout --orphan`. Deliberate choice, checked against git
+// 2.54: `git branch --show-current` prints the name but `git rev-parse
+// --abbrev-ref HEAD` exits 128, and rev-parse is what the subprocess path runs
+// and what gopen has always matched. Answering would be a silent divergence
+// *and* a URL for a branch no forge has yet, so this refuses and lets the
+// fallback produce the same error gopen returned before the fast path existed.
+func headCommit(commonDir, branch string) (string, error) {
+	oid, ok := refOID(commonDir, headRefPrefix+branch)
+	if !ok {
+		return "", fmt.Errorf("branch %q has no commit yet", branch)
+	}
+	return oid, nil
+}
+
+// reftableHEAD is branchFromHEAD and headCommit for a repository whose refs
+// live in reftables. There .git/HEAD is only a decoy, "ref:
+// refs/heads/.invalid", kept so that older git versions refuse the repository;
+// the real HEAD is a record in the stack of the current worktree's gitDir,
+// while the branch it names lives in the stack of the common dir.
+//
+// The unborn-branch refusal is the same as headCommit's, for the same reason.
+func reftableHEAD(gitDir, commonDir string) (branch, commit string, err error) {
+	head, ok, err := reftableLookup(gitDir, "HEAD")
+	if err != nil {
+		return "", "", err
+	}
+	if !ok {
+		return "", "", errors.New("HEAD is missing from the reftable stack")
+	}
+	if head.target == "" {
+		return detachedHEAD, head.oid, nil
+	}
+
+	branch, ok = strings.CutPrefix(head.target, headRefPrefix)
+	if !ok || !isValidBranchName(branch) {
+		return "", "", fmt.Errorf("HEAD points at %q, which is not a branch", head.target)
+	}
+	ref, ok, err := reftableLookup(commonDir, head.target)
+	if err != nil {
+		return "", "", err
+	}
+	if !ok || ref.oid == "" {
+		// A missing record is an unborn branch; a symref-to-symref is legal
+		// but rare enough to leave to git.
+		return "", "", fmt.Errorf("branch %q has no commit yet", branch)
+	}
+	return branch, ref.oid, nil
+}
+
+// reftableRef is the value of one ref record: an object id, or the target of a
+// symbolic ref. Exactly one of the two is set.
+type reftableRef struct {
+	oid    string // lowercase hex, the ref's own value; a peeled tag's target is not kept
+	target string
+}
+
+// Reftable value types, the low three bits of a ref record's second varint.
+const (
+	reftableDeletion = 0x0
+	reftableVal1     = 0x1 // one object id
+	reftableVal2     = 0x2 // object id and its peeled target
+	reftableSymref   = 0x3
+)
+
+// reftableLookup resolves name in the reftable stack under dir/reftable. The
+// stack is the tables listed in tables.list, oldest first; the newest table
+// holding a record for name decides, and a deletion record there means the ref
+// does not exist even if an older table still has it.
+//
+// Every table is read in full and checked against its footer CRC. The format is
+// documented in git's Documentation/technical/reftable.txt; only the ref blocks
+// are parsed, since neither the index, object nor log blocks can change a ref's
+// value. Anything unexpected is an error, which sends the caller to git.
+func reftableLookup(dir, name string) (reftableRef, bool, error) {
+	stackDir := filepath.Join(dir, "reftable")
+	raw, err := os.ReadFile(filepath.Join(stackDir, "tables.list"))
+	if err != nil {
+		return reftableRef{}, false, fmt.Errorf("failed to read the reftable stack: %w", err)
+	}
+	tables := strings.Split(strings.TrimSuffix(string(raw), "\n"), "\n")
+	for i := len(tables) - 1; i >= 0; i-- {
+		table := tables[i]
+		if table == "" || table != filepath.Base(table) {
+			return reftableRef{}, false, fmt.Errorf("unexpected entry %q in tables.list", table)
+		}
+		ref, deleted, found, err := reftableTableLookup(filepath.Join(stackDir, table), name)
+		if err != nil {
+			return reftableRef{}, false, err
+		}
+		if found {
+			return ref, !deleted, nil
+		}
+	}
+	return reftableRef{}, false, nil
+}
+
+// reftableTableLookup scans the ref blocks of one table for name. deleted
+// reports a deletion record, which shadows older tables.
+func reftableTableLookup(path, name string) (ref reftableRef, deleted, found bool, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return reftableRef{}, false, false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	t, err := parseReftable(data)
+	if err != nil {
+		return reftableRef{}, false, false, fmt.Errorf("%s: %w", path, err)
+	}
+	ref, deleted, found, err = t.lookup(name)
+	if err != nil {
+		return reftableRef{}, false, false, fmt.Errorf("%s: %w", path, err)
+	}
+	return ref, deleted, found, nil
+}
+
+// reftable is one table file, vetted against its footer.
+type reftable struct {
+	data       []byte
+	headerSize int
+	hashSize   int
+	blockSize  int
+	end        int // where the footer starts
+}
+
+// parseReftable checks a table's header and footer. Version 1 tables always
+// hold SHA-1 ids; version 2 adds a hash id to the header.
+func parseReftable(data []byte) (reftable, error) {
+	if len(data) < 24 || string(data[:4]) != "REFT" {
+		return reftable{}, errors.New("not a reftable")
+	}
+	t := reftable{data: data, blockSize: int(uint24(data[5:8]))}
+	var footerSize int
+	switch data[4] {
+	case 1:
+		t.headerSize, footerSize, t.hashSize = 24, 68, 20
+	case 2:
+		t.headerSize, footerSize = 28, 72
+		if len(data) < t.headerSize {
+			return reftable{}, errors.New("truncated reftable header")
+		}
+		switch string(data[24:28]) {
+		case "sha1":
+			t.hashSize = 20
+		case "s256":
+			t.hashSize = 32
+		default:
+			return reftable{}, fmt.Errorf("unknown reftable hash id %q", data[24:28])
+		}
+	default:
+		return reftable{}, fmt.Errorf("unsupported reftable version %d", data[4])
+	}
+	if len(data) < t.headerSize+footerSize {
+		return reftable{}, errors.New("truncated reftable")
+	}
+
+	t.end = len(data) - footerSize
+	footer := data[t.end:]
+	if !bytes.Equal(footer[:t.headerSize], data[:t.headerSize]) {
+		return reftable{}, errors.New("reftable footer does not repeat the header")
+	}
+	if crc32.ChecksumIEEE(footer[:footerSize-4]) != binary.BigEndian.Uint32(footer[footerSize-4:]) {
+		return reftable{}, errors.New("reftable footer checksum mismatch")
+	}
+	return t, nil
+}
+
+// lookup walks the ref blocks in order. They come first in the file, the first
+// one sharing its block with the file header, and end at the first block of
+// any other type.
+func (t reftable) lookup(name string) (ref reftableRef, deleted, found bool, err error) {
+	for start := 0; ; {
+		pos := start
+		if start == 0 {
+			pos = t.headerSize
+		}
+		if pos+4 > t.end || t.data[pos] != 'r' {
+			return reftableRef{}, false, false, nil
+		}
+		// block_len counts from the start of the block, which for the first
+		// one is the start of the file.
+		blockEnd := start + int(uint24(t.data[pos+1:pos+4]))
+		if blockEnd <= pos+4 || blockEnd > t.end {
+			return reftableRef{}, false, false, errors.New("reftable block overruns the file")
+		}
+		ref, deleted, found, past, err := t.scanRefBlock(t.data[start:blockEnd], pos-start+4, name)
+		if err != nil || found || past {
+			return ref, deleted, found, err
+		}
+
+		// Blocks are padded with NULs to the block size, unless the writer
+		// packed them back to back; git tells the two apart by the byte right
+		// after the block, and so does this.
+		switch {
+		case t.blockSize == 0 || (blockEnd < t.end && t.data[blockEnd] != 0):
+			start = blockEnd
+		case start+t.blockSize > blockEnd:
+			start += t.blockSize
+		default:
+			return reftableRef{}, false, false, errors.New("reftable block exceeds the block size")
+		}
+	}
+}
+
+// scanRefBlock reads the records of one ref block, from recStart up to its
+// restart table. Names are prefix-compressed against the previous record and
+// sorted, so past reports that the scan went beyond where name would be.
+func (t reftable) scanRefBlock(block []byte, recStart int, name string) (ref reftableRef, deleted, found, past bool, err error) {
+	if len(block) < recStart+2 {
+		return reftableRef{}, false, false, false, errors.New("truncated reftable block")
+	}
+	restarts := int(binary.BigEndian.Uint16(block[len(block)-2:]))
+	recEnd := len(block) - 2 - 3*restarts
+	if restarts == 0 || recEnd < recStart {
+		return reftableRef{}, false, false, false, errors.New("malformed reftable restart table")
+	}
+
+	r := reftableReader{buf: block[:recEnd], pos: recStart}
+	var prev []byte
+	for r.pos < len(r.buf) {
+		prefix := r.varint()
+		suffixAndType := r.varint()
+		suffix := r.bytes(suffixAndType >> 3)
+		r.varint() // update_index delta
+		if r.err != nil || prefix > uint64(len(prev)) {
+			return reftableRef{}, false, false, false, errors.New("malformed reftable ref record")
+		}
+		key := append(prev[:prefix:prefix], suffix...)
+
+		var rec reftableRef
+		switch suffixAndType & 0x7 {
+		case reftableDeletion:
+		case reftableVal1:
+			rec.oid = hex.EncodeToString(r.bytes(uint64(t.hashSize)))
+		case reftableVal2:
+			rec.oid = hex.EncodeToString(r.bytes(uint64(t.hashSize)))
+			r.bytes(uint64(t.hashSize)) // peeled target
+		case reftableSymref:
+			rec.target = string(r.bytes(r.varint()))
+		default:
+			return reftableRef{}, false, false, false, fmt.Errorf("unknown reftable value type %d", suffixAndType&0x7)
+		}
+		if r.err != nil {
+			return reftableRef{}, false, false, false, errors.New("malformed reftable ref record")
+		}
+
+		switch cmp := strings.Compare(string(key), name); {
+		case cmp == 0:
+			return rec, suffixAndType&0x7 == reftableDeletion, true, false, nil
+		case cmp > 0:
+			return reftableRef{}, false, false, true, nil
+		}
+		prev = key
+	}
+	return reftableRef{}, false, false, false, nil
+}
+
+// reftableReader decodes the primitives of a ref record. The first error
+// sticks, so a record can be read in full and checked once.
+type reftableReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+// varint decodes reftable's varint, the same encoding git uses for pack
+// offsets: every continuation byte adds one before shifting, so each value has
+// exactly one encoding.
+func (r *reftableReader) varint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	var v uint64
+	for i := 0; ; i++ {
+		if r.pos >= len(r.buf) || i > 9 {
+			r.err = errors.New("malformed varint")
+			return 0
+		}
+		c := r.buf[r.pos]
+		r.pos++
+		if i == 0 {
+			v = uint64(c & 0x7f)
+		} else {
+			v = (v+1)<<7 | uint64(c&0x7f)
+		}
+		if c&0x80 == 0 {
+			return v
+		}
+	}
+}
+
+// bytes returns the next n bytes.
+func (r *reftableReader) bytes(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.buf)-r.pos) {
+		r.err = errors.New("record overruns its block")
+		return nil
+	}
+	b := r.buf[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b
+}
+
+// uint24 decodes a big-endian 24-bit integer.
+func uint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
 }
 
 // branchFromHEAD reads gitDir/HEAD and returns the short branch name.
 // A detached HEAD yields the literal "HEAD", which is what
-// `git rev-parse --abbrev-ref HEAD` prints in that state.
-func branchFromHEAD(gitDir string) (string, error) {
+// `git rev-parse --abbrev-ref HEAD` prints in that state, along with the
+// commit it holds, so that the URL can name the commit instead.
+func branchFromHEAD(gitDir string) (branch, detached string, err error) {
 	path := filepath.Join(gitDir, "HEAD")
 
 	// core.preferSymlinkRefs makes HEAD a symlink to the loose ref file, and
@@ -294,15 +626,15 @@ func branchFromHEAD(gitDir string) (string, error) {
 	// worth the code: refuse and let the git binary answer.
 	info, err := os.Lstat(path)
 	if err != nil {
-		return "", fmt.Errorf("failed to stat HEAD: %w", err)
+		return "", "", fmt.Errorf("failed to stat HEAD: %w", err)
 	}
 	if info.Mode()&os.ModeSymlink != 0 {
-		return "", errors.New("HEAD is a symlink (core.preferSymlinkRefs), which this cannot resolve")
+		return "", "", errors.New("HEAD is a symlink (core.preferSymlinkRefs), which this cannot resolve")
 	}
 
 	raw, err := os.ReadFile(path)
 	if err != nil {
-		return "", fmt.Errorf("failed to read HEAD: %w", err)
+		return "", "", fmt.Errorf("failed to read HEAD: %w", err)
 	}
 	head := strings.TrimSpace(string(raw))
 
@@ -311,7 +643,7 @@ func branchFromHEAD(gitDir string) (string, error) {
 	// "ref: " prefix.
 	if ref, ok := strings.CutPrefix(head, "ref:"); ok {
 		ref = strings.TrimSpace(ref)
-		branch, ok := strings.CutPrefix(ref, headRefPrefix)
+		branch, ok = strings.CutPrefix(ref, headRefPrefix)
 		// A bare prefix match isn't enough: anything trailing the ref on
 		// the same line (extra tokens, embedded whitespace) or spilling
 		// onto another line would otherwise be swallowed into what looks
@@ -319,15 +651,16 @@ func branchFromHEAD(gitDir string) (string, error) {
 		// HEAD as a ref at all, so reject it here too rather than risk
 		// silently returning a name git would never produce.
 		if !ok || !isValidBranchName(branch) {
-			return "", fmt.Errorf("HEAD points at %q, which is not a branch", ref)
+			return "", "", fmt.Errorf("HEAD points at %q, which is not a branch", ref)
 		}
-		return branch, nil
+		return branch, "", nil
 	}
 
 	if isHexSHA(head) {
-		return detachedHEAD, nil
+		// git accepts either case on read but always prints lowercase.
+		return detachedHEAD, strings.ToLower(head), nil
 	}
-	return "", fmt.Errorf("unrecognized HEAD content: %q", head)
+	return "", "", fmt.Errorf("unrecognized HEAD content: %q", head)
 }
 
 // isValidBranchName reports whether s is a name git itself would accept under
@@ -397,7 +730,7 @@ var gitDiscoveryEnvVars = []string{
 
 // gitDiscoveryEnvOverride returns the name of the first variable from
 // gitDiscoveryEnvVars that is set, or "" when none is. It is the single home
-// for that check: both the walk and needsGitFallback ask it rather than each
+// for that check: both the walk and scanConfigScopes ask it rather than each
 // testing its own subset.
 func gitDiscoveryEnvOverride() string {
 	for _, name := range gitDiscoveryEnvVars {
@@ -409,10 +742,10 @@ func gitDiscoveryEnvOverride() string {
 }
 
 // safeRepoExtensions lists the extensions.* keys that cannot change how the
-// branch, the remote URL or the work tree are resolved. Anything else — most
-// importantly extensions.refstorage=reftable, where .git/HEAD is the decoy
-// "ref: refs/heads/.invalid" — has to be refused. extensions.worktreeConfig is
-// handled separately, by reading the per-worktree config it enables.
+// branch, the remote URL or the work tree are resolved. Anything else has to be
+// refused. extensions.worktreeConfig is handled separately, by reading the
+// per-worktree config it enables, and extensions.refStorage by switching to the
+// reftable reader.
 var safeRepoExtensions = map[string]bool{
 	"objectformat":       true, // sha1 or sha256; both are plain hex in HEAD
 	"compatobjectformat": true,
@@ -431,6 +764,7 @@ type repoLayout struct {
 	commonDir string
 	workTree  string
 	config    []configEntry // <commonDir>/config, in file order
+	reftable  bool          // extensions.refStorage=reftable: refs live in reftable/, not in files
 }
 
 // discoverRepoLayout walks up from start until it finds a .git entry, and
@@ -538,7 +872,14 @@ func describeRepo(gitDir, workTree string) (repoLayout, error) {
 	if err := checkRepoConfig(entries, gitDir, commonDir, workTree); err != nil {
 		return repoLayout{}, fmt.Errorf("%s: %w", gitDir, err)
 	}
-	return repoLayout{gitDir: gitDir, commonDir: commonDir, workTree: workTree, config: entries}, nil
+	storage, _ := lastConfigValue(entries, "extensions.refstorage")
+	return repoLayout{
+		gitDir:    gitDir,
+		commonDir: commonDir,
+		workTree:  workTree,
+		config:    entries,
+		reftable:  strings.EqualFold(storage, "reftable"),
+	}, nil
 }
 
 const (
@@ -670,9 +1011,14 @@ func checkRepoConfig(entries []configEntry, gitDir, commonDir, workTree string)
 		}
 		switch {
 		case name == "refstorage":
-			if !strings.EqualFold(e.value, "files") {
+			if !strings.EqualFold(e.value, "files") && !strings.EqualFold(e.value, "reftable") {
 				return fmt.Errorf("unsupported ref storage %q", e.value)
 			}
+			// git refuses a v1-only extension in a v0 repository outright, so
+			// there is no answer to match.
+			if version, _ := lastConfigValue(entries, "core.repositoryformatversion"); version != "1" {
+				return fmt.Errorf("extensions.refStorage needs repository format version 1, not %q", version)
+			}
 		case name == "worktreeconfig":
 			// Rejecting on the key alone would be too blunt: `git
 			// sparse-checkout set`, `scalar clone` and `scalar register` all
@@ -808,17 +1154,22 @@ func configBool(value string) (result, known bool) {
 	return false, false
 }
 
-// needsGitFallback reports whether the pure-Go path must defer to the git
-// binary. It is deliberately conservative: a false positive costs one fork, a
-// false negative costs a wrong URL.
+// scanConfigScopes reports whether the pure-Go path must defer to the git
+// binary, and otherwise returns what it gathered from every scope: the
+// url.<base>.insteadOf rules, so the caller can rewrite the remote URL the way
+// `git remote get-url` does, and the gopen.<host>.type bindings that pick a
+// provider for a self-hosted forge. It is
+// deliberately conservative: a false positive costs one fork, a false negative
+// costs a wrong URL.
 //
 // The work is delegated to configScanner, which parses every scope git would
 // read and follows its include directives, so that an include only disqualifies
 // the fast path when the file it pulls in really does define something the
-// answer depends on.
-func needsGitFallback(gitDir, commonDir, remoteName string) bool {
+// answer depends on. ownKeys are the keys the caller reads from the
+// repository's config file itself; set in any other file, they defer to git.
+func scanConfigScopes(gitDir, commonDir string, ownKeys ...string) (scopedConfig, bool) {
 	if gitDiscoveryEnvOverride() != "" {
-		return true
+		return scopedConfig{}, true
 	}
 	// Both of git's environment config channels inject settings — including
 	// url.*.insteadOf — that no file scan can see. GIT_CONFIG_COUNT/KEY/VALUE
@@ -827,22 +1178,39 @@ func needsGitFallback(gitDir, commonDir, remoteName string) bool {
 	// documented git alias.
 	for _, name := range []string{"GIT_CONFIG_COUNT", "GIT_CONFIG_PARAMETERS"} {
 		if os.Getenv(name) != "" {
-			return true
+			return scopedConfig{}, true
 		}
 	}
 
-	s := configScanner{gitDir: gitDir, remoteURLKey: "remote." + remoteName + ".url"}
+	// Scopes are scanned in git's own order — system, global, then the
+	// repository — because the order rewrites are met in is what breaks a tie
+	// between two equally long insteadOf prefixes.
+	s := configScanner{gitDir: gitDir, ownKeys: make(map[string]bool)}
+	for _, key := range ownKeys {
+		s.ownKeys[key] = true
+	}
 	for _, p := range outerConfigScopePaths() {
-		if s.scanFile(p, false, 0) {
-			return true
+		if s.scanFile(p, false, false, 0) {
+			return scopedConfig{}, true
 		}
 	}
 	for _, p := range repoConfigScopePaths(gitDir, commonDir) {
-		if s.scanFile(p, true, 0) {
-			return true
+		// Only the shared config is certain to be read. The per-worktree files
+		// are scanned whether or not extensions.worktreeConfig is on, so a
+		// rewrite found there cannot be applied and must defer instead.
+		speculative := filepath.Base(p) == "config.worktree"
+		if s.scanFile(p, true, speculative, 0) {
+			return scopedConfig{}, true
 		}
 	}
-	return false
+	return s.scopedConfig, false
+}
+
+// scopedConfig is what the fast path takes from every config scope, as opposed
+// to the repository's own file alone.
+type scopedConfig struct {
+	rewrites  urlRewrites       // url.<base>.insteadOf rules, in git's order
+	hostTypes map[string]string // gopen.<host>.type; a later scope overrides
 }
 
 // outerConfigScopePaths lists the system and global config files, the scopes
@@ -958,32 +1326,41 @@ const (
 )
 
 // configScanner decides whether anything in the config scopes could make the
-// fast path's answer differ from git's.
+// fast path's answer differ from git's, and collects the URL rewrites it can
+// apply itself.
 //
 // It resolves include directives rather than refusing on sight: an include only
 // matters when the file it pulls in actually defines something the answer
 // depends on, and `includeIf` is common enough in corporate setups that
 // refusing on the keyword alone disabled the fast path for entire populations.
 //
-// The scanner never merges what it finds. It only ever answers "the git binary
-// must handle this", so every uncertainty resolves to a fallback.
+// The scanner never merges what it finds beyond the insteadOf rules, and those
+// only from files git is certain to read. Everything else it only ever answers
+// "the git binary must handle this", so every uncertainty resolves to a
+// fallback.
 type configScanner struct {
-	gitDir       string // the repository's git directory, for gitdir: conditions
-	remoteURLKey string // remote.<name>.url, the one key the fast path reads
-
-	gitDirReal string // symlink-resolved gitDir, computed on first use
-	resolved   bool   // whether gitDirReal has been computed
-	filesRead  int
-	forced     bool // git would abort where the scan could not follow it
+	gitDir  string          // the repository's git directory, for gitdir: conditions
+	ownKeys map[string]bool // keys the fast path reads from the repository's own config
+
+	scopedConfig        // settings met so far
+	gitDirReal   string // symlink-resolved gitDir, computed on first use
+	resolved     bool   // whether gitDirReal has been computed
+	filesRead    int
+	forced       bool // git would abort where the scan could not follow it
 }
 
 // scanFile reports whether path, or anything it includes, forces the fallback.
 //
 // own marks the repository's own config files. Those the fast path reads and
-// vets itself, so only a URL rewrite disqualifies them. Every other file —
-// system, global, and anything included from anywhere — is judged more strictly
-// because its contents are never merged in.
-func (s *configScanner) scanFile(path string, own bool, depth int) bool {
+// vets itself, so only ownKeys and layout keys are left to it. Every
+// other file — system, global, and anything included from anywhere — is judged
+// more strictly because its contents are never merged in.
+//
+// speculative marks a file git may or may not read: one pulled in by an
+// includeIf condition this cannot evaluate, or a per-worktree file. Its
+// insteadOf rules and host types cannot be applied without knowing, so any of
+// them forces the fallback instead.
+func (s *configScanner) scanFile(path string, own, speculative bool, depth int) bool {
 	s.filesRead++
 	if s.filesRead > maxIncludeFiles {
 		return true
@@ -997,10 +1374,32 @@ func (s *configScanner) scanFile(path string, own bool, depth int) bool {
 	}
 
 	for _, e := range entries {
-		if rewritesRemoteURL(e.key) {
+		if base, ok := insteadOfBase(e.key); ok {
+			// An empty base rewrites a URL down to its bare suffix, which is
+			// git's literal behaviour but never what anyone meant; not worth
+			// reproducing.
+			if speculative || base == "" {
+				return true
+			}
+			s.rewrites = s.rewrites.add(base, e.value)
+			continue
+		}
+		if host, ok := hostTypeHost(e.key); ok {
+			if speculative {
+				return true
+			}
+			if s.hostTypes == nil {
+				s.hostTypes = make(map[string]string)
+			}
+			s.hostTypes[host] = e.value
+			continue
+		}
+		// config.worktree is the repository's own, but not the file the
+		// caller reads ownKeys from.
+		if (!own || speculative) && s.ownKeys[e.key] {
 			return true
 		}
-		if !own && (e.key == s.remoteURLKey || affectsRepoLayout(e.key)) {
+		if !own && affectsRepoLayout(e.key) {
 			return true
 		}
 
@@ -1008,14 +1407,16 @@ func (s *configScanner) scanFile(path string, own bool, depth int) bool {
 		if !isInclude {
 			continue
 		}
+		includeSpeculative := speculative
 		if cond != "" {
-			mayHold := s.conditionMayHold(cond)
+			holds, known := s.conditionHolds(cond)
 			if s.forced {
 				return true
 			}
-			if !mayHold {
+			if known && !holds {
 				continue
 			}
+			includeSpeculative = includeSpeculative || !known
 		}
 		if depth+1 > maxIncludeDepth {
 			return true // git aborts past this depth
@@ -1024,20 +1425,81 @@ func (s *configScanner) scanFile(path string, own bool, depth int) bool {
 		if !ok {
 			return true
 		}
-		if s.scanFile(target, false, depth+1) {
+		if s.scanFile(target, false, includeSpeculative, depth+1) {
 			return true
 		}
 	}
 	return false
 }
 
-// rewritesRemoteURL reports whether key is a url.<base>.insteadOf or
-// url.<base>.pushInsteadOf, the directives that rewrite a remote's URL. They
-// disqualify the fast path from any scope, including the repository's own
-// config, because the fast path reports the raw configured URL.
-func rewritesRemoteURL(key string) bool {
-	return strings.HasPrefix(key, "url.") &&
-		(strings.HasSuffix(key, ".insteadof") || strings.HasSuffix(key, ".pushinsteadof"))
+// insteadOfBase reports whether key is a url.<base>.insteadOf, the directive
+// that rewrites a remote's URL, and returns its base. url.<base>.pushInsteadOf
+// is deliberately not matched: it only rewrites push URLs, and `git remote
+// get-url` without --push never consults it.
+//
+// A bare [url] section with no base is ignored by git, and so it is here.
+func insteadOfBase(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, "url.")
+	if !ok {
+		return "", false
+	}
+	return strings.CutSuffix(rest, ".insteadof")
+}
+
+// hostTypeHost reports whether key is a gopen.<host>.type, which binds a host
+// to one of the providers by name, and returns the host. A subsection keeps its
+// case, but host names do not have one, so the host comes back lowercased.
+func hostTypeHost(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, "gopen.")
+	if !ok {
+		return "", false
+	}
+	host, ok := strings.CutSuffix(rest, ".type")
+	return strings.ToLower(host), ok && host != ""
+}
+
+// urlRewrite is every insteadOf prefix configured for one url.<base> section.
+type urlRewrite struct {
+	base      string
+	insteadOf []string
+}
+
+// urlRewrites holds the insteadOf rules in scope, one entry per base in the
+// order git first meets each base. Like git's rewrites table it is keyed by
+// base, so a base repeated in a later scope adds to its first entry rather than
+// starting a new one, which is what decides ties in apply.
+type urlRewrites []urlRewrite
+
+// add records one insteadOf value for base.
+func (r urlRewrites) add(base, insteadOf string) urlRewrites {
+	for i := range r {
+		if r[i].base == base {
+			r[i].insteadOf = append(r[i].insteadOf, insteadOf)
+			return r
+		}
+	}
+	return append(r, urlRewrite{base: base, insteadOf: []string{insteadOf}})
+}
+
+// apply rewrites url the way git's alias_url does: the longest matching
+// insteadOf prefix wins, and between two of the same length the one met first
+// does. A URL no rule matches comes back unchanged.
+func (r urlRewrites) apply(url string) string {
+	var (
+		base    string
+		longest = -1
+	)
+	for _, rw := range r {
+		for _, prefix := range rw.insteadOf {
+			if strings.HasPrefix(url, prefix) && len(prefix) > longest {
+				base, longest = rw.base, len(prefix)
+			}
+		}
+	}
+	if longest < 0 {
+		return url
+	}
+	return base + url[longest:]
 }
 
 // affectsRepoLayout reports whether key could move the work tree or change how
@@ -1128,32 +1590,34 @@ func expandHome(p string) (string, bool) {
 	return home + rest, true
 }
 
-// conditionMayHold reports whether an includeIf condition could be true.
+// conditionHolds evaluates an includeIf condition. The second result reports
+// whether the answer is known; when it is not, the included file is still read
+// but only judged, never applied (see scanFile).
 //
 // Only the plain gitdir: form is evaluated. gitdir/i: brings ASCII case folding
 // into the glob, onbranch: matches the checked-out branch and
 // hasconfig:remote.*.url: matches every configured remote URL; none is
-// reproduced here, so each reports "maybe", which costs nothing more than
+// reproduced here, so each answers "unknown", which costs nothing more than
 // reading the file it would include and judging that on its contents.
 //
-// An unrecognized condition is skipped outright because git treats it as false.
-func (s *configScanner) conditionMayHold(cond string) bool {
+// An unrecognized condition is known false because git treats it as false.
+func (s *configScanner) conditionHolds(cond string) (holds, known bool) {
 	switch {
 	case strings.HasPrefix(cond, "gitdir:"):
-		return s.gitDirMayMatch(strings.TrimPrefix(cond, "gitdir:"))
+		return s.gitDirMatches(strings.TrimPrefix(cond, "gitdir:"))
 	case strings.HasPrefix(cond, "gitdir/i:"),
 		strings.HasPrefix(cond, "onbranch:"),
 		strings.HasPrefix(cond, "hasconfig:remote.*.url:"):
-		return true
+		return false, false
 	default:
-		return false
+		return false, true
 	}
 }
 
-// gitDirMayMatch evaluates a gitdir: condition against the repository's git
+// gitDirMatches evaluates a gitdir: condition against the repository's git
 // directory, mirroring git's include_by_gitdir over the subset it can reproduce
 // exactly: an absolute pattern with no wildcards. Anything else answers
-// "maybe".
+// "unknown".
 //
 // git matches the pattern with wildmatch(WM_PATHNAME) against the
 // symlink-resolved git directory, after appending "**" to a pattern that ends
@@ -1162,28 +1626,28 @@ func (s *configScanner) conditionMayHold(cond string) bool {
 // fails git retries against a symlink-resolved pattern, which is the second
 // pass below; its realpath tolerates a missing final component and fails on
 // anything missing earlier, and a failed retry is a definite non-match.
-func (s *configScanner) gitDirMayMatch(pattern string) bool {
+func (s *configScanner) gitDirMatches(pattern string) (holds, known bool) {
 	if filepath.Separator != '/' {
 		// git normalizes both sides to forward slashes on Windows and this does
 		// not, so the comparison would not be git's.
-		return true
+		return false, false
 	}
 	if pattern == "" || strings.ContainsAny(pattern, `*?[]\`) {
-		return true
+		return false, false
 	}
 
 	p, ok := s.expandRealHome(pattern)
 	if !ok || !strings.HasPrefix(p, "/") {
 		// A relative pattern gains a "**/" prefix and a "./" one is taken
 		// against the including file; neither is reproduced here.
-		return true
+		return false, false
 	}
 	text, ok := s.realGitDir()
 	if !ok {
-		return true
+		return false, false
 	}
 	if literalGitDirMatch(p, text) {
-		return true
+		return true, true
 	}
 
 	resolved, err := filepath.EvalSymlinks(strings.TrimSuffix(p, "/"))
@@ -1192,13 +1656,13 @@ func (s *configScanner) gitDirMayMatch(pattern string) bool {
 		if strings.HasSuffix(p, "/") {
 			resolved += "/"
 		}
-		return literalGitDirMatch(resolved, text)
+		return literalGitDirMatch(resolved, text), true
 	case errors.Is(err, os.ErrNotExist):
 		// git's realpath fails the same way, leaving the condition false. A
 		// path that does not exist also cannot be the git directory, which does.
-		return false
+		return false, true
 	default:
-		return true
+		return false, false
 	}
 }
 
@@ -1263,33 +1727,27 @@ func readRepoContextFromDisk(targetPath, remoteName string) (repoContext, error)
 
 	// The walk only vets the repository's shape. This is the second gate, on
 	// the configuration that could rewrite the URL out from under us.
-	if needsGitFallback(layout.gitDir, layout.commonDir, remoteName) {
+	scoped, fallback := scanConfigScopes(layout.gitDir, layout.commonDir, "remote."+remoteName+".url")
+	if fallback {
 		return repoContext{}, errors.New("configuration in scope can rewrite the remote URL")
 	}
 
-	branch, err := branchFromHEAD(layout.gitDir)
+	branch, commit, err := resolveHEAD(layout)
 	if err != nil {
 		return repoContext{}, err
 	}
 
-	// Unborn branch: HEAD names a branch that has no commit yet, right after
-	// `git init` or `git checkout --orphan`. Deliberate choice, checked against
-	// git 2.54: `git branch --show-current` prints the name but
-	// `git rev-parse --abbrev-ref HEAD` exits 128, and rev-parse is what the
-	// subprocess path runs and what gopen has always matched. Answering here
-	// would be a silent divergence *and* a URL for a branch no forge has yet,
-	// so refuse and let the fallback produce the same error gopen returned
-	// before the fast path existed.
-	if branch != detachedHEAD && !branchIsBorn(layout.commonDir, branch) {
-		return repoContext{}, fmt.Errorf("branch %q has no commit yet", branch)
-	}
-
 	// firstConfigValue, not lastConfigValue: `git remote get-url` returns a
 	// remote's *first* url line.
 	remoteURL, ok := firstConfigValue(layout.config, "remote."+remoteName+".url")
 	if !ok {
 		return repoContext{}, fmt.Errorf("no URL configured for remote %q", remoteName)
 	}
+	remoteURL = scoped.rewrites.apply(remoteURL)
+	baseURL, forge, err := remoteWebURL(remoteURL, scoped.hostTypes)
+	if err != nil {
+		return repoContext{}, err
+	}
 
 	relPath, err := relativeToRoot(layout.workTree, target)
 	if err != nil {
@@ -1297,12 +1755,259 @@ func readRepoContextFromDisk(targetPath, remoteName string) (repoContext, error)
 	}
 
 	return repoContext{
-		baseURL: convertToHTTPS(remoteURL),
-		branch:  branch,
-		relPath: relPath,
+		baseURL:   baseURL,
+		forge:     forge,
+		remote:    remoteName,
+		remoteURL: remoteURL,
+		branch:    branch,
+		commit:    commit,
+		root:      layout.workTree,
+		relPath:   relPath,
+		reader:    readerGo,
 	}, nil
 }
 
+// readRemoteHEADFromDisk returns the branch refs/remotes/<remote>/HEAD points
+// at, without the "<remote>/" prefix: the remote's default branch as of the
+// last clone or `git remote set-head`. It is the answer `git symbolic-ref
+// refs/remotes/<remote>/HEAD` gives, read from the common dir.
+//
+// packed-refs cannot hold a symbolic ref, so with the files backend the loose
+// file is the only place to look. As with HEAD, a symlink is left to git.
+func readRemoteHEADFromDisk(targetPath, remoteName string) (string, error) {
+	dir, _, err := resolveTarget(targetPath)
+	if err != nil {
+		return "", err
+	}
+	layout, err := discoverRepoLayout(dir)
+	if err != nil {
+		return "", err
+	}
+
+	name := "refs/remotes/" + remoteName + "/HEAD"
+	var target string
+	if layout.reftable {
+		ref, ok, err := reftableLookup(layout.commonDir, name)
+		if err != nil {
+			return "", err
+		}
+		if !ok || ref.target == "" {
+			return "", fmt.Errorf("%s is not a symbolic ref", name)
+		}
+		target = ref.target
+	} else {
+		path := filepath.Join(layout.commonDir, filepath.FromSlash(name))
+		info, err := os.Lstat(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to stat %s: %w", name, err)
+		}
+		if !info.Mode().IsRegular() {
+			return "", fmt.Errorf("%s is not a regular file", name)
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		ref, ok := strings.CutPrefix(strings.TrimSpace(string(raw)), "ref:")
+		if !ok {
+			return "", fmt.Errorf("%s is not a symbolic ref", name)
+		}
+		target = strings.TrimSpace(ref)
+	}
+
+	branch, ok := strings.CutPrefix(target, "refs/remotes/"+remoteName+"/")
+	if !ok || !isValidBranchName(branch) {
+		return "", fmt.Errorf("%s points at %q, outside the remote's namespace", name, target)
+	}
+	return branch, nil
+}
+
+// readRefFromDisk returns the full name of the ref name abbreviates, by the
+// rules of gitrevisions(7): name itself, then refs/<name>, refs/tags/<name>,
+// refs/heads/<name>, refs/remotes/<name> and refs/remotes/<name>/HEAD. It is
+// the answer `git rev-parse --symbolic-full-name` gives, which refuses a name
+// more than one rule matches — so every rule is tried, not just up to the
+// first hit.
+//
+// Only the refs every worktree shares are read, from the common dir. A name
+// that could reach anything else — a pseudo-ref such as FETCH_HEAD, a
+// per-worktree ref under refs/bisect/ — or that matches a symbolic ref is
+// refused, for git to answer.
+func readRefFromDisk(targetPath, name string) (string, error) {
+	if !isValidBranchName(name) {
+		return "", fmt.Errorf("%q is not a ref name this can resolve", name)
+	}
+	short := strings.TrimPrefix(name, "refs/")
+	for _, prefix := range []string{"bisect/", "worktree/", "rewritten/", "main-worktree/", "worktrees/"} {
+		if strings.HasPrefix(short, prefix) {
+			return "", fmt.Errorf("%q may name a per-worktree ref", name)
+		}
+	}
+
+	dir, _, err := resolveTarget(targetPath)
+	if err != nil {
+		return "", err
+	}
+	layout, err := discoverRepoLayout(dir)
+	if err != nil {
+		return "", err
+	}
+
+	candidates := []string{"refs/" + name, "refs/tags/" + name, headRefPrefix + name, "refs/remotes/" + name, "refs/remotes/" + name + "/HEAD"}
+	if strings.HasPrefix(name, "refs/") {
+		candidates = append([]string{name}, candidates...)
+	} else {
+		// git looks name up at the top of the git dir too, where only
+		// pseudo-refs live. Whatever is there is git's to interpret.
+		for _, d := range []string{layout.gitDir, layout.commonDir} {
+			if _, err := os.Lstat(filepath.Join(d, name)); err == nil {
+				return "", fmt.Errorf("%q also names %s", name, filepath.Join(d, name))
+			}
+		}
+	}
+
+	var found string
+	for _, ref := range candidates {
+		_, ok, err := sharedRefOID(layout, ref)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			continue
+		}
+		if found != "" {
+			return "", fmt.Errorf("%q is ambiguous: %s and %s", name, found, ref)
+		}
+		found = ref
+	}
+	if found == "" {
+		return "", fmt.Errorf("no ref named %q", name)
+	}
+	return found, nil
+}
+
+// sharedRefOID returns the object id of ref, a plain ref in the common dir, and
+// whether it exists at all. A ref that exists but is not plain — a symbolic ref, a symlink, a file that does
+// not hold an object id — is an error, not a miss: git would still find it.
+//
+// A directory where the loose file would be is a miss, as for git: it is the
+// parent of other refs (refs/heads/release for refs/heads/release/1.0), and
+// the ref itself may still be packed.
+func sharedRefOID(layout repoLayout, ref string) (string, bool, error) {
+	if layout.reftable {
+		r, ok, err := reftableLookup(layout.commonDir, ref)
+		if err != nil || !ok {
+			return "", false, err
+		}
+		if r.target != "" {
+			return "", false, fmt.Errorf("%s is a symbolic ref", ref)
+		}
+		return r.oid, true, nil
+	}
+
+	info, err := os.Lstat(filepath.Join(layout.commonDir, filepath.FromSlash(ref)))
+	if err == nil && !info.IsDir() {
+		oid, ok := refOID(layout.commonDir, ref)
+		if !ok {
+			return "", false, fmt.Errorf("%s is not a plain ref", ref)
+		}
+		return oid, true, nil
+	}
+	oid, ok := refOID(layout.commonDir, ref)
+	return oid, ok, nil
+}
+
+// readTrackingCommitFromDisk returns the commit refs/remotes/<remote>/<branch>
+// holds, loose or packed, and whether the remote-tracking branch exists: what
+// `git rev-parse --verify --quiet` answers for the full ref name.
+func readTrackingCommitFromDisk(targetPath, remote, branch string) (string, bool, error) {
+	dir, _, err := resolveTarget(targetPath)
+	if err != nil {
+		return "", false, err
+	}
+	layout, err := discoverRepoLayout(dir)
+	if err != nil {
+		return "", false, err
+	}
+	// The name is a path under the common dir, so it is vetted like one.
+	if !isValidBranchName(remote + "/" + branch) {
+		return "", false, fmt.Errorf("%s/%s is not a ref name this can read", remote, branch)
+	}
+	return sharedRefOID(layout, "refs/remotes/"+remote+"/"+branch)
+}
+
+// readUpstreamFromDisk returns the remote branch tracks and the branch's name
+// there, read from branch.<name>.remote and branch.<name>.merge: what `git
+// for-each-ref --format=%(upstream:remotename) %(upstream:remoteref)` reports.
+// Both come back empty when there is no such upstream — none configured, or a
+// local one (remote ".").
+//
+// git only names an upstream its fetch refspecs map to a remote-tracking ref,
+// so only the default refspec, +refs/heads/*:refs/remotes/<remote>/*, is
+// accepted here. Any other refspec, and any of these keys set outside the
+// repository's config file, is left to git.
+func readUpstreamFromDisk(targetPath, branch string) (remote, remoteBranch string, err error) {
+	dir, _, err := resolveTarget(targetPath)
+	if err != nil {
+		return "", "", err
+	}
+	layout, err := discoverRepoLayout(dir)
+	if err != nil {
+		return "", "", err
+	}
+
+	remoteKey, mergeKey := "branch."+branch+".remote", "branch."+branch+".merge"
+	remote, hasRemote := lastConfigValue(layout.config, remoteKey)
+	merge, hasMerge := firstConfigValue(layout.config, mergeKey)
+	fetchKey := "remote." + remote + ".fetch"
+	if _, fallback := scanConfigScopes(layout.gitDir, layout.commonDir, remoteKey, mergeKey, fetchKey); fallback {
+		return "", "", errors.New("configuration in scope can change the upstream")
+	}
+	if !hasMerge || remote == "." {
+		return "", "", nil
+	}
+	if !hasRemote || strings.Contains(remote, "/") {
+		// Without a remote git names no upstream; a slash would put the
+		// remote's name in the same namespace as another remote's branches.
+		return "", "", fmt.Errorf("upstream remote %q is not one this can map", remote)
+	}
+	remoteBranch, ok := strings.CutPrefix(merge, headRefPrefix)
+	if !ok {
+		return "", "", fmt.Errorf("upstream %q is not a branch", merge)
+	}
+
+	var fetch []string
+	for _, e := range layout.config {
+		if e.key == fetchKey {
+			fetch = append(fetch, e.value)
+		}
+	}
+	if len(fetch) != 1 || strings.TrimPrefix(fetch[0], "+") != "refs/heads/*:refs/remotes/"+remote+"/*" {
+		return "", "", fmt.Errorf("remote %q does not use the default fetch refspec", remote)
+	}
+	return remote, remoteBranch, nil
+}
+
+// resolveHEAD returns the short branch name and the commit HEAD resolves to,
+// from whichever ref storage the repository uses.
+func resolveHEAD(layout repoLayout) (branch, commit string, err error) {
+	if layout.reftable {
+		return reftableHEAD(layout.gitDir, layout.commonDir)
+	}
+	branch, commit, err = branchFromHEAD(layout.gitDir)
+	if err != nil {
+		return "", "", err
+	}
+	if branch == detachedHEAD {
+		return branch, commit, nil
+	}
+	commit, err = headCommit(layout.commonDir, branch)
+	if err != nil {
+		return "", "", err
+	}
+	return branch, commit, nil
+}
+
 // resolveTarget returns the directory the walk must start from and the target
 // path expressed in that same namespace.
 //
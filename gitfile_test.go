@@ -4,6 +4,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"strings"
 	"testing"
@@ -213,10 +214,11 @@ func TestFirstConfigValue(t *testing.T) {
 
 func TestBranchFromHEAD(t *testing.T) {
 	tests := []struct {
-		name    string
-		head    string
-		want    string
-		wantErr bool
+		name         string
+		head         string
+		want         string
+		wantDetached string
+		wantErr      bool
 	}{
 		{
 			name: "simple branch",
@@ -234,9 +236,16 @@ func TestBranchFromHEAD(t *testing.T) {
 			want: "main",
 		},
 		{
-			name: "detached HEAD returns the literal HEAD, as git does",
-			head: "9f2c1b7e4a8d3f6019b5c2e7a4d8f1b3c6e9a2d5\n",
-			want: "HEAD",
+			name:         "detached HEAD returns the literal HEAD, as git does, and its commit",
+			head:         "9f2c1b7e4a8d3f6019b5c2e7a4d8f1b3c6e9a2d5\n",
+			want:         "HEAD",
+			wantDetached: "9f2c1b7e4a8d3f6019b5c2e7a4d8f1b3c6e9a2d5",
+		},
+		{
+			name:         "a detached commit is lowercased, as git prints it",
+			head:         "9F2C1B7E4A8D3F6019B5C2E7A4D8F1B3C6E9A2D5\n",
+			want:         "HEAD",
+			wantDetached: "9f2c1b7e4a8d3f6019b5c2e7a4d8f1b3c6e9a2d5",
 		},
 		{
 			name:    "symref outside refs/heads is not a branch",
@@ -333,7 +342,7 @@ func TestBranchFromHEAD(t *testing.T) {
 			if err := os.WriteFile(filepath.Join(dir, "HEAD"), []byte(tt.head), 0o644); err != nil {
 				t.Fatal(err)
 			}
-			got, err := branchFromHEAD(dir)
+			got, detached, err := branchFromHEAD(dir)
 			if tt.wantErr {
 				if err == nil {
 					t.Fatalf("expected an error, got %q", got)
@@ -343,14 +352,14 @@ func TestBranchFromHEAD(t *testing.T) {
 			if err != nil {
 				t.Fatalf("branchFromHEAD() error = %v", err)
 			}
-			if got != tt.want {
-				t.Errorf("branchFromHEAD() = %q, want %q", got, tt.want)
+			if got != tt.want || detached != tt.wantDetached {
+				t.Errorf("branchFromHEAD() = (%q, %q), want (%q, %q)", got, detached, tt.want, tt.wantDetached)
 			}
 		})
 	}
 
 	t.Run("missing HEAD file errors", func(t *testing.T) {
-		if _, err := branchFromHEAD(t.TempDir()); err == nil {
+		if _, _, err := branchFromHEAD(t.TempDir()); err == nil {
 			t.Error("expected an error for a missing HEAD file")
 		}
 	})
@@ -366,12 +375,20 @@ func TestBranchFromHEAD(t *testing.T) {
 		if err := os.Symlink(filepath.Join("refs", "heads", "main"), filepath.Join(dir, "HEAD")); err != nil {
 			t.Skipf("symlinks unavailable: %v", err)
 		}
-		if got, err := branchFromHEAD(dir); err == nil {
+		if got, _, err := branchFromHEAD(dir); err == nil {
 			t.Errorf("branchFromHEAD() = %q, want an error so the caller falls back to git", got)
 		}
 	})
 }
 
+// branchIsBorn reports whether refs/heads/<branch> resolves. It lives here
+// rather than in gitfile.go for the same reason as discoverGitDir: headCommit
+// asks refOID directly, so only the tests want the boolean form.
+func branchIsBorn(commonDir, branch string) bool {
+	_, ok := refOID(commonDir, headRefPrefix+branch)
+	return ok
+}
+
 func TestBranchIsBorn(t *testing.T) {
 	const sha = "9f2c1b7e4a8d3f6019b5c2e7a4d8f1b3c6e9a2d5"
 
@@ -428,6 +445,426 @@ func TestBranchIsBorn(t *testing.T) {
 	})
 }
 
+func TestRefOID(t *testing.T) {
+	const sha = "9f2c1b7e4a8d3f6019b5c2e7a4d8f1b3c6e9a2d5"
+	const other = "0123456789abcdef0123456789abcdef01234567"
+
+	t.Run("loose ref wins over packed-refs", func(t *testing.T) {
+		dir := t.TempDir()
+		mkdirAll(t, filepath.Join(dir, "refs", "heads"))
+		writeFile(t, filepath.Join(dir, "refs", "heads", "main"), sha+"\n")
+		writeFile(t, filepath.Join(dir, "packed-refs"), other+" refs/heads/main\n")
+		if got, ok := refOID(dir, "refs/heads/main"); !ok || got != sha {
+			t.Errorf("refOID() = (%q, %v), want (%q, true)", got, ok, sha)
+		}
+	})
+
+	t.Run("packed ref", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "packed-refs"),
+			"# pack-refs with: peeled fully-peeled sorted \n"+other+" refs/heads/main\n")
+		if got, ok := refOID(dir, "refs/heads/main"); !ok || got != other {
+			t.Errorf("refOID() = (%q, %v), want (%q, true)", got, ok, other)
+		}
+	})
+
+	t.Run("uppercase is reported the way git prints it", func(t *testing.T) {
+		dir := t.TempDir()
+		mkdirAll(t, filepath.Join(dir, "refs", "heads"))
+		writeFile(t, filepath.Join(dir, "refs", "heads", "main"), strings.ToUpper(sha)+"\n")
+		if got, ok := refOID(dir, "refs/heads/main"); !ok || got != sha {
+			t.Errorf("refOID() = (%q, %v), want (%q, true)", got, ok, sha)
+		}
+	})
+
+	// A loose symref shadows the packed entry in git, so falling through to
+	// packed-refs would report a commit the ref does not point at.
+	t.Run("a loose symref is not resolved, and does not fall through", func(t *testing.T) {
+		dir := t.TempDir()
+		mkdirAll(t, filepath.Join(dir, "refs", "heads"))
+		writeFile(t, filepath.Join(dir, "refs", "heads", "main"), "ref: refs/heads/other\n")
+		writeFile(t, filepath.Join(dir, "packed-refs"), other+" refs/heads/main\n")
+		if got, ok := refOID(dir, "refs/heads/main"); ok {
+			t.Errorf("refOID() = %q, want not found so the caller falls back to git", got)
+		}
+	})
+}
+
+func TestHeadCommit(t *testing.T) {
+	const sha = "9f2c1b7e4a8d3f6019b5c2e7a4d8f1b3c6e9a2d5"
+
+	t.Run("branch resolves through the common dir", func(t *testing.T) {
+		commonDir := t.TempDir()
+		mkdirAll(t, filepath.Join(commonDir, "refs", "heads"))
+		writeFile(t, filepath.Join(commonDir, "refs", "heads", "main"), sha+"\n")
+		got, err := headCommit(commonDir, "main")
+		if err != nil || got != sha {
+			t.Errorf("headCommit() = (%q, %v), want (%q, nil)", got, err, sha)
+		}
+	})
+
+	t.Run("unborn branch errors", func(t *testing.T) {
+		if got, err := headCommit(t.TempDir(), "main"); err == nil {
+			t.Errorf("headCommit() = %q, want an error for a branch with no commit", got)
+		}
+	})
+}
+
+// --- readRemoteHEADFromDisk ---
+
+func TestReadRemoteHEADFromDisk(t *testing.T) {
+	t.Run("matches git", func(t *testing.T) {
+		dir := newTmpGitRepo(t)
+		runGit(t, dir, "symbolic-ref", "refs/remotes/origin/HEAD", "refs/remotes/origin/release/2.x")
+		want := strings.TrimPrefix(gitOut(t, dir, "symbolic-ref", "--short", "refs/remotes/origin/HEAD"), "origin/")
+		got, err := readRemoteHEADFromDisk(dir, "origin")
+		if err != nil || got != want {
+			t.Errorf("readRemoteHEADFromDisk() = (%q, %v), want (%q, nil)", got, err, want)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		dir := newTmpGitRepo(t)
+		if got, err := readRemoteHEADFromDisk(dir, "origin"); err == nil {
+			t.Errorf("readRemoteHEADFromDisk() = %q, want an error", got)
+		}
+	})
+
+	t.Run("points into another remote", func(t *testing.T) {
+		dir := newTmpGitRepo(t)
+		mkdirAll(t, filepath.Join(dir, ".git", "refs", "remotes", "origin"))
+		writeFile(t, filepath.Join(dir, ".git", "refs", "remotes", "origin", "HEAD"), "ref: refs/remotes/upstream/main\n")
+		if got, err := readRemoteHEADFromDisk(dir, "origin"); err == nil {
+			t.Errorf("readRemoteHEADFromDisk() = %q, want an error", got)
+		}
+	})
+
+	t.Run("reftable", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := tryGit(dir, "init", "--ref-format=reftable", "."); err != nil {
+			t.Skipf("git does not support --ref-format=reftable: %v", err)
+		}
+		runGit(t, dir, "symbolic-ref", "refs/remotes/origin/HEAD", "refs/remotes/origin/main")
+		got, err := readRemoteHEADFromDisk(dir, "origin")
+		if err != nil || got != "main" {
+			t.Errorf("readRemoteHEADFromDisk() = (%q, %v), want (%q, nil)", got, err, "main")
+		}
+	})
+}
+
+func TestReadRefFromDisk(t *testing.T) {
+	// Whatever the fast path answers must be what git answers; everything
+	// else it may refuse.
+	t.Run("matches git", func(t *testing.T) {
+		dir := newTmpGitRepo(t)
+		runGit(t, dir, "branch", "feature/x")
+		runGit(t, dir, "branch", "release/1.0")
+		runGit(t, dir, "tag", "v1.0")
+		runGit(t, dir, "tag", "release")
+		runGit(t, dir, "update-ref", "refs/remotes/origin/main", "HEAD")
+		runGit(t, dir, "pack-refs", "--all")
+		runGit(t, dir, "tag", "v2.0")
+		for _, name := range []string{"feature/x", "release/1.0", "v1.0", "v2.0", "release", "origin/main", "refs/tags/v1.0", "heads/feature/x"} {
+			want := gitOut(t, dir, "rev-parse", "--symbolic-full-name", name)
+			got, err := readRefFromDisk(dir, name)
+			if err != nil || got != want {
+				t.Errorf("readRefFromDisk(%q) = (%q, %v), want (%q, nil)", name, got, err, want)
+			}
+		}
+	})
+
+	refuses := []struct {
+		name  string
+		setup [][]string
+		ref   string
+	}{
+		{name: "ambiguous", setup: [][]string{{"branch", "dup"}, {"tag", "dup"}}, ref: "dup"},
+		{name: "symbolic ref", setup: [][]string{{"update-ref", "refs/remotes/origin/main", "HEAD"}, {"symbolic-ref", "refs/remotes/origin/HEAD", "refs/remotes/origin/main"}}, ref: "origin"},
+		{name: "pseudo-ref", ref: "HEAD"},
+		{name: "per-worktree ref", setup: [][]string{{"update-ref", "refs/bisect/bad", "HEAD"}}, ref: "bisect/bad"},
+		{name: "missing", ref: "no-such-ref"},
+	}
+	for _, tt := range refuses {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newTmpGitRepo(t)
+			for _, args := range tt.setup {
+				runGit(t, dir, args...)
+			}
+			if got, err := readRefFromDisk(dir, tt.ref); err == nil {
+				t.Errorf("readRefFromDisk(%q) = %q, want an error", tt.ref, got)
+			}
+		})
+	}
+
+	t.Run("reftable", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := tryGit(dir, "init", "--ref-format=reftable", "."); err != nil {
+			t.Skipf("git does not support --ref-format=reftable: %v", err)
+		}
+		runGit(t, dir, "-c", "user.email=test@test.com", "-c", "user.name=Test", "commit", "--allow-empty", "-m", "init")
+		runGit(t, dir, "tag", "v1.0")
+		got, err := readRefFromDisk(dir, "v1.0")
+		if err != nil || got != "refs/tags/v1.0" {
+			t.Errorf("readRefFromDisk() = (%q, %v), want (%q, nil)", got, err, "refs/tags/v1.0")
+		}
+	})
+}
+
+func TestReadUpstreamFromDisk(t *testing.T) {
+	// trackingRepo is on branch feature, with origin configured as `git
+	// remote add` leaves it.
+	trackingRepo := func(t *testing.T) string {
+		dir := newTmpGitRepo(t)
+		runGit(t, dir, "checkout", "-q", "-b", "feature")
+		runGit(t, dir, "remote", "add", "origin", "https://github.com/user/repo")
+		return dir
+	}
+
+	answers := []struct {
+		name       string
+		setup      [][]string
+		wantRemote string
+		wantBranch string
+	}{
+		{
+			name: "tracks a branch of another name",
+			setup: [][]string{
+				{"update-ref", "refs/remotes/origin/jsmith/feature", "HEAD"},
+				{"branch", "--set-upstream-to=origin/jsmith/feature"},
+			},
+			wantRemote: "origin",
+			wantBranch: "jsmith/feature",
+		},
+		{
+			name: "packed remote-tracking ref",
+			setup: [][]string{
+				{"update-ref", "refs/remotes/origin/feature", "HEAD"},
+				{"branch", "--set-upstream-to=origin/feature"},
+				{"pack-refs", "--all"},
+			},
+			wantRemote: "origin",
+			wantBranch: "feature",
+		},
+		{name: "no upstream"},
+		{
+			name:  "local upstream",
+			setup: [][]string{{"branch", "base"}, {"branch", "--set-upstream-to=base"}},
+		},
+		{
+			name:       "remote-tracking ref never fetched",
+			setup:      [][]string{{"config", "branch.feature.remote", "origin"}, {"config", "branch.feature.merge", "refs/heads/feature"}},
+			wantRemote: "origin",
+			wantBranch: "feature",
+		},
+	}
+	for _, tt := range answers {
+		t.Run(tt.name, func(t *testing.T) {
+			pinConfigScope(t)
+			dir := trackingRepo(t)
+			for _, args := range tt.setup {
+				runGit(t, dir, args...)
+			}
+			// The expectation is git's own answer, not just the table's.
+			gitRemote, merge, _ := strings.Cut(gitOut(t, dir, "for-each-ref", "--format=%(upstream:remotename) %(upstream:remoteref)", "refs/heads/feature"), " ")
+			gitBranch, _ := strings.CutPrefix(merge, "refs/heads/")
+			if gitRemote == "." {
+				gitRemote, gitBranch = "", ""
+			}
+			if gitRemote != tt.wantRemote || gitBranch != tt.wantBranch {
+				t.Fatalf("precondition: git says (%q, %q), want (%q, %q)", gitRemote, gitBranch, tt.wantRemote, tt.wantBranch)
+			}
+
+			remote, branch, err := readUpstreamFromDisk(dir, "feature")
+			if err != nil || remote != tt.wantRemote || branch != tt.wantBranch {
+				t.Errorf("readUpstreamFromDisk() = (%q, %q, %v), want (%q, %q, nil)", remote, branch, err, tt.wantRemote, tt.wantBranch)
+			}
+		})
+	}
+
+	refuses := []struct {
+		name  string
+		setup func(t *testing.T, dir string)
+	}{
+		{
+			name: "custom fetch refspec",
+			setup: func(t *testing.T, dir string) {
+				runGit(t, dir, "config", "remote.origin.fetch", "+refs/heads/*:refs/remotes/mirror/*")
+				runGit(t, dir, "config", "branch.feature.remote", "origin")
+				runGit(t, dir, "config", "branch.feature.merge", "refs/heads/feature")
+			},
+		},
+		{
+			name: "upstream set in the global config",
+			setup: func(t *testing.T, dir string) {
+				global := filepath.Join(t.TempDir(), "gitconfig")
+				writeFile(t, global, "[branch \"feature\"]\n\tremote = origin\n\tmerge = refs/heads/feature\n")
+				t.Setenv("GIT_CONFIG_GLOBAL", global)
+			},
+		},
+		{
+			name: "remote name with a slash",
+			setup: func(t *testing.T, dir string) {
+				runGit(t, dir, "remote", "add", "team/fork", "https://github.com/team/repo")
+				runGit(t, dir, "config", "branch.feature.remote", "team/fork")
+				runGit(t, dir, "config", "branch.feature.merge", "refs/heads/feature")
+			},
+		},
+	}
+	for _, tt := range refuses {
+		t.Run(tt.name, func(t *testing.T) {
+			pinConfigScope(t)
+			dir := trackingRepo(t)
+			tt.setup(t, dir)
+			if remote, branch, err := readUpstreamFromDisk(dir, "feature"); err == nil {
+				t.Errorf("readUpstreamFromDisk() = (%q, %q), want an error", remote, branch)
+			}
+		})
+	}
+}
+
+func TestReadTrackingCommitFromDisk(t *testing.T) {
+	answers := []struct {
+		name      string
+		setup     [][]string
+		wantFound bool
+	}{
+		{name: "loose", setup: [][]string{{"update-ref", "refs/remotes/origin/feature", "HEAD"}}, wantFound: true},
+		{name: "packed", setup: [][]string{{"update-ref", "refs/remotes/origin/feature", "HEAD"}, {"pack-refs", "--all"}}, wantFound: true},
+		{name: "missing"},
+		{name: "only branches below it", setup: [][]string{{"update-ref", "refs/remotes/origin/feature/x", "HEAD"}}},
+	}
+	for _, tt := range answers {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newTmpGitRepo(t)
+			for _, args := range tt.setup {
+				runGit(t, dir, args...)
+			}
+			var want string
+			if tt.wantFound {
+				want = gitOut(t, dir, "rev-parse", "--verify", "refs/remotes/origin/feature")
+			} else if err := tryGit(dir, "rev-parse", "--verify", "--quiet", "refs/remotes/origin/feature"); err == nil {
+				t.Fatal("precondition: git finds refs/remotes/origin/feature")
+			}
+
+			got, found, err := readTrackingCommitFromDisk(dir, "origin", "feature")
+			if err != nil || got != want || found != tt.wantFound {
+				t.Errorf("readTrackingCommitFromDisk() = (%q, %v, %v), want (%q, %v, nil)", got, found, err, want, tt.wantFound)
+			}
+		})
+	}
+
+	t.Run("symbolic ref is refused", func(t *testing.T) {
+		dir := newTmpGitRepo(t)
+		runGit(t, dir, "update-ref", "refs/remotes/origin/main", "HEAD")
+		runGit(t, dir, "symbolic-ref", "refs/remotes/origin/feature", "refs/remotes/origin/main")
+		if got, found, err := readTrackingCommitFromDisk(dir, "origin", "feature"); err == nil {
+			t.Errorf("readTrackingCommitFromDisk() = (%q, %v), want an error", got, found)
+		}
+	})
+
+	t.Run("reftable", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := tryGit(dir, "init", "--ref-format=reftable", "."); err != nil {
+			t.Skipf("git does not support --ref-format=reftable: %v", err)
+		}
+		runGit(t, dir, "-c", "user.email=test@test.com", "-c", "user.name=Test", "commit", "--allow-empty", "-m", "init")
+		runGit(t, dir, "update-ref", "refs/remotes/origin/feature", "HEAD")
+		want := gitOut(t, dir, "rev-parse", "HEAD")
+		got, found, err := readTrackingCommitFromDisk(dir, "origin", "feature")
+		if err != nil || got != want || !found {
+			t.Errorf("readTrackingCommitFromDisk() = (%q, %v, %v), want (%q, true, nil)", got, found, err, want)
+		}
+	})
+}
+
+// --- reftable ---
+
+// The fixtures under testdata/reftable are hand-built tables; see the README
+// there for what each one holds.
+func reftableFixture(name string) string {
+	return filepath.Join("testdata", "reftable", name)
+}
+
+func TestReftableLookup(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		ref     string
+		want    reftableRef
+		wantOK  bool
+		wantErr bool
+	}{
+		{name: "symref", fixture: "basic", ref: "HEAD", want: reftableRef{target: "refs/heads/main"}, wantOK: true},
+		{name: "branch", fixture: "basic", ref: "refs/heads/main", want: reftableRef{oid: "0d6e4079e36703ebd37c00722f5891d28b0e2811"}, wantOK: true},
+		{name: "prefix-compressed name", fixture: "basic", ref: "refs/heads/feature/x", want: reftableRef{oid: "217d2bf51f16e9dc49a2286889946c839c86af7d"}, wantOK: true},
+		{name: "peeled tag keeps its own id", fixture: "basic", ref: "refs/tags/v1.0", want: reftableRef{oid: "8633a2ada7d79e45bc6d543cdae5efc75c39207a"}, wantOK: true},
+		{name: "missing ref", fixture: "basic", ref: "refs/heads/nope", wantOK: false},
+		{name: "missing ref past the last record", fixture: "basic", ref: "zzz", wantOK: false},
+		{name: "newer table wins", fixture: "stack", ref: "refs/heads/main", want: reftableRef{oid: "4bd72ba74a66da56f29e3a37f4fcf6a0aa42716e"}, wantOK: true},
+		{name: "deletion hides an older record", fixture: "stack", ref: "refs/heads/gone", wantOK: false},
+		{name: "older table still answers", fixture: "stack", ref: "refs/heads/kept", want: reftableRef{oid: "79f076abdd19a752db7267bfff2f9022161d120d"}, wantOK: true},
+		{name: "first of many padded blocks", fixture: "multiblock", ref: "refs/heads/branch-00", want: reftableRef{oid: "09efbc4b8e1d908fe0478e5529afd04c5415686b"}, wantOK: true},
+		{name: "last of many padded blocks", fixture: "multiblock", ref: "refs/heads/branch-39", want: reftableRef{oid: "04231cdfff8e1c09b1b6884daeac01f51c315340"}, wantOK: true},
+		{name: "unpadded blocks", fixture: "unaligned", ref: "refs/heads/branch-39", want: reftableRef{oid: "04231cdfff8e1c09b1b6884daeac01f51c315340"}, wantOK: true},
+		{name: "sha256 table", fixture: "sha256", ref: "refs/heads/main", want: reftableRef{oid: "0d6e4079e36703ebd37c00722f5891d28b0e2811dc114b129215123adcce3605"}, wantOK: true},
+		{name: "corrupt footer", fixture: "corrupt", ref: "HEAD", wantErr: true},
+		{name: "no stack", fixture: "does-not-exist", ref: "HEAD", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := reftableLookup(reftableFixture(tt.fixture), tt.ref)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("reftableLookup() = (%+v, %v), want an error", got, ok)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("reftableLookup() error = %v", err)
+			}
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("reftableLookup(%q) = (%+v, %v), want (%+v, %v)", tt.ref, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestReftableHEAD(t *testing.T) {
+	tests := []struct {
+		name       string
+		gitDir     string
+		commonDir  string
+		wantBranch string
+		wantCommit string
+		wantErr    bool
+	}{
+		{name: "branch", gitDir: "basic", commonDir: "basic", wantBranch: "main", wantCommit: "0d6e4079e36703ebd37c00722f5891d28b0e2811"},
+		{name: "detached", gitDir: "detached", commonDir: "detached", wantBranch: detachedHEAD, wantCommit: "88e34e4cdbb5c6066cb1b0d0abe74714bd72f862"},
+		{name: "branch past the first block", gitDir: "multiblock", commonDir: "multiblock", wantBranch: "branch-17", wantCommit: "b31a178903a2e08a5f6f32ccfc6036b01bcad032"},
+		// A worktree keeps its own HEAD; the branch it names is shared.
+		{name: "worktree HEAD, shared branch", gitDir: "worktree", commonDir: "basic", wantBranch: "feature/x", wantCommit: "217d2bf51f16e9dc49a2286889946c839c86af7d"},
+		{name: "unborn branch", gitDir: "unborn", commonDir: "unborn", wantErr: true},
+		{name: "corrupt table", gitDir: "corrupt", commonDir: "corrupt", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			branch, commit, err := reftableHEAD(reftableFixture(tt.gitDir), reftableFixture(tt.commonDir))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("reftableHEAD() = (%q, %q), want an error", branch, commit)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("reftableHEAD() error = %v", err)
+			}
+			if branch != tt.wantBranch || commit != tt.wantCommit {
+				t.Errorf("reftableHEAD() = (%q, %q), want (%q, %q)", branch, commit, tt.wantBranch, tt.wantCommit)
+			}
+		})
+	}
+}
+
 // --- discoverGitDir ---
 
 // discoverGitDir flattens discoverRepoLayout to the three paths these tests
@@ -643,8 +1080,8 @@ func TestDiscoverGitDir_RefusesWhatItCannotReproduce(t *testing.T) {
 			t.Skipf("git does not support --ref-format=reftable: %v", err)
 		}
 		// The trap: .git/HEAD reads "ref: refs/heads/.invalid" while the real
-		// branch lives in .git/reftable. Reading HEAD would look plausible and
-		// be wrong, so the whole repository has to be refused.
+		// branch lives in .git/reftable. Discovery must accept the repository
+		// and flag it, so that HEAD is read from the stack and not the decoy.
 		head, err := os.ReadFile(filepath.Join(dir, ".git", "HEAD"))
 		if err != nil {
 			t.Fatal(err)
@@ -652,8 +1089,21 @@ func TestDiscoverGitDir_RefusesWhatItCannotReproduce(t *testing.T) {
 		if !strings.Contains(string(head), ".invalid") {
 			t.Fatalf("precondition: unexpected reftable HEAD %q", head)
 		}
-		if _, _, _, err := discoverGitDir(dir); err == nil {
-			t.Error("expected an error for a reftable-backed repository")
+		l, err := discoverRepoLayout(dir)
+		if err != nil {
+			t.Fatalf("discoverRepoLayout() error = %v", err)
+		}
+		if !l.reftable {
+			t.Error("layout.reftable = false for a reftable-backed repository")
+		}
+	})
+
+	t.Run("reftable storage in a version 0 repository", func(t *testing.T) {
+		root := newTmpGitRepo(t)
+		runGit(t, root, "config", "core.repositoryformatversion", "0")
+		runGit(t, root, "config", "extensions.refStorage", "reftable")
+		if _, _, _, err := discoverGitDir(root); err == nil {
+			t.Error("expected an error for extensions.refStorage without format version 1")
 		}
 	})
 
@@ -1033,6 +1483,34 @@ func localScope(t *testing.T, content string) string {
 
 const cleanConfig = "[remote \"origin\"]\n\turl = https://example.com/r.git\n"
 
+// needsGitFallback is scanConfigScopes reduced to its verdict, which is all
+// most of these tests assert on. Like discoverGitDir it lives here so the
+// binary does not ship an adapter only the tests call.
+func needsGitFallback(gitDir, commonDir, remoteName string) bool {
+	_, fallback := scanConfigScopes(gitDir, commonDir, "remote."+remoteName+".url")
+	return fallback
+}
+
+// scopeRewrites returns the insteadOf rules scanConfigScopes collects for a
+// plain repository scope, failing the test if it defers to git instead.
+func scopeRewrites(t *testing.T, dir string) urlRewrites {
+	t.Helper()
+	scoped, fallback := scanConfigScopes(dir, dir, "remote.origin.url")
+	if fallback {
+		t.Fatal("scanConfigScopes() deferred to git, want the rewrites resolved")
+	}
+	return scoped.rewrites
+}
+
+// rewriteAToB is the rule most scope tests plant: whether it comes back from
+// scopeRewrites is how they tell the file was read.
+const rewriteAToB = "[url \"a\"]\n\tinsteadOf = b\n"
+
+// hasRewriteAToB reports whether rewriteAToB was collected.
+func hasRewriteAToB(r urlRewrites) bool {
+	return r.apply("b/x") == "a/x"
+}
+
 func TestNeedsGitFallback(t *testing.T) {
 	t.Run("clean scope does not need the fallback", func(t *testing.T) {
 		pinConfigScope(t)
@@ -1074,40 +1552,92 @@ func TestNeedsGitFallback(t *testing.T) {
 		}
 	})
 
-	markers := []struct{ name, content string }{
-		{"insteadOf", "[url \"git@github.com:\"]\n\tinsteadOf = https://github.com/\n"},
-		{"uppercase INSTEADOF", "[url \"x\"]\n\tINSTEADOF = y\n"},
-		{"pushInsteadOf", "[url \"x\"]\n\tpushInsteadOf = y\n"},
+	markers := []struct {
+		name, content string
+		want          string // what https://github.com/u/r resolves to
+	}{
+		{"insteadOf", "[url \"git@github.com:\"]\n\tinsteadOf = https://github.com/\n", "git@github.com:u/r"},
+		{"uppercase INSTEADOF", "[url \"git@github.com:\"]\n\tINSTEADOF = https://github.com/\n", "git@github.com:u/r"},
+		// Only `git remote get-url --push` consults it, which gopen never runs.
+		{"pushInsteadOf", "[url \"git@github.com:\"]\n\tpushInsteadOf = https://github.com/\n", "https://github.com/u/r"},
 	}
 
 	for _, m := range markers {
-		t.Run(m.name+" in the global config forces the fallback", func(t *testing.T) {
+		t.Run(m.name+" in the global config is resolved", func(t *testing.T) {
 			pinConfigScope(t)
 			t.Setenv("GIT_CONFIG_GLOBAL", writeConfig(t, m.content))
 			dir := localScope(t, cleanConfig)
-			if !needsGitFallback(dir, dir, "origin") {
-				t.Errorf("needsGitFallback() = false, want true for %s", m.name)
+			if got := scopeRewrites(t, dir).apply("https://github.com/u/r"); got != m.want {
+				t.Errorf("rewritten URL = %q, want %q", got, m.want)
 			}
 		})
 
-		t.Run(m.name+" in the system config forces the fallback", func(t *testing.T) {
+		t.Run(m.name+" in the system config is resolved", func(t *testing.T) {
 			pinConfigScope(t)
 			t.Setenv("GIT_CONFIG_SYSTEM", writeConfig(t, m.content))
 			dir := localScope(t, cleanConfig)
-			if !needsGitFallback(dir, dir, "origin") {
-				t.Errorf("needsGitFallback() = false, want true for %s", m.name)
+			if got := scopeRewrites(t, dir).apply("https://github.com/u/r"); got != m.want {
+				t.Errorf("rewritten URL = %q, want %q", got, m.want)
 			}
 		})
 
-		t.Run(m.name+" in the local config forces the fallback", func(t *testing.T) {
+		t.Run(m.name+" in the local config is resolved", func(t *testing.T) {
 			pinConfigScope(t)
 			dir := localScope(t, m.content)
-			if !needsGitFallback(dir, dir, "origin") {
-				t.Errorf("needsGitFallback() = false, want true for %s", m.name)
+			if got := scopeRewrites(t, dir).apply("https://github.com/u/r"); got != m.want {
+				t.Errorf("rewritten URL = %q, want %q", got, m.want)
 			}
 		})
 	}
 
+	// git's tie-break between equally long prefixes follows the order bases
+	// are first met in, system before global before local.
+	t.Run("rules from every scope are merged in git's order", func(t *testing.T) {
+		pinConfigScope(t)
+		t.Setenv("GIT_CONFIG_SYSTEM", writeConfig(t, "[url \"sys:\"]\n\tinsteadOf = https://github.com/\n"))
+		t.Setenv("GIT_CONFIG_GLOBAL", writeConfig(t, "[url \"glob:\"]\n\tinsteadOf = https://github.com/\n"))
+		dir := localScope(t, "[url \"local:\"]\n\tinsteadOf = https://github.com/u/\n")
+		r := scopeRewrites(t, dir)
+		if got, want := r.apply("https://github.com/u/r"), "local:r"; got != want {
+			t.Errorf("the longest prefix must win: got %q, want %q", got, want)
+		}
+		if got, want := r.apply("https://github.com/v/r"), "sys:v/r"; got != want {
+			t.Errorf("a tie goes to the first scope: got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("host types are collected, a later scope overriding", func(t *testing.T) {
+		pinConfigScope(t)
+		t.Setenv("GIT_CONFIG_GLOBAL", writeConfig(t,
+			"[gopen \"a.example\"]\n\ttype = gitlab\n[gopen \"B.example\"]\n\ttype = gitea\n"))
+		dir := localScope(t, "[gopen \"a.example\"]\n\ttype = gogs\n")
+		scoped, fallback := scanConfigScopes(dir, dir, "remote.origin.url")
+		if fallback {
+			t.Fatal("scanConfigScopes() deferred to git, want the host types resolved")
+		}
+		want := map[string]string{"a.example": "gogs", "b.example": "gitea"}
+		if !reflect.DeepEqual(scoped.hostTypes, want) {
+			t.Errorf("hostTypes = %v, want %v", scoped.hostTypes, want)
+		}
+	})
+
+	t.Run("a host type in a per-worktree file forces the fallback", func(t *testing.T) {
+		pinConfigScope(t)
+		dir := localScope(t, cleanConfig)
+		writeFile(t, filepath.Join(dir, "config.worktree"), "[gopen \"a.example\"]\n\ttype = gitlab\n")
+		if !needsGitFallback(dir, dir, "origin") {
+			t.Error("needsGitFallback() = false, want true for a host type git may not read")
+		}
+	})
+
+	t.Run("an empty base forces the fallback", func(t *testing.T) {
+		pinConfigScope(t)
+		dir := localScope(t, "[url \"\"]\n\tinsteadOf = https://github.com/\n")
+		if !needsGitFallback(dir, dir, "origin") {
+			t.Error("needsGitFallback() = false, want true for [url \"\"]")
+		}
+	})
+
 	// An outer scope wins for `git remote get-url`, which returns the *first*
 	// url across all scopes; the fast path only ever reads the repository's own
 	// config. Verified against git 2.54: a global remote.origin.url really does
@@ -1130,8 +1660,8 @@ func TestNeedsGitFallback(t *testing.T) {
 		}
 	})
 
-	// The per-worktree file is read whenever extensions.worktreeConfig is on,
-	// and it can carry an insteadOf like any other config file.
+	// The per-worktree file is read only when extensions.worktreeConfig is on,
+	// which the scan does not know, so an insteadOf there cannot be applied.
 	t.Run("insteadOf in config.worktree forces the fallback", func(t *testing.T) {
 		for _, where := range []string{"gitDir", "commonDir"} {
 			t.Run(where, func(t *testing.T) {
@@ -1153,7 +1683,7 @@ func TestNeedsGitFallback(t *testing.T) {
 	t.Run("GIT_CONFIG_NOSYSTEM only suppresses the system scope when true", func(t *testing.T) {
 		for _, tc := range []struct {
 			value string
-			want  bool
+			want  bool // whether the system config is read
 		}{
 			{"1", false}, {"true", false}, {"yes", false},
 			{"0", true}, {"false", true}, {"", true},
@@ -1161,11 +1691,11 @@ func TestNeedsGitFallback(t *testing.T) {
 		} {
 			t.Run("value="+tc.value, func(t *testing.T) {
 				pinConfigScope(t)
-				t.Setenv("GIT_CONFIG_SYSTEM", writeConfig(t, "[url \"a\"]\n\tinsteadOf = b\n"))
+				t.Setenv("GIT_CONFIG_SYSTEM", writeConfig(t, rewriteAToB))
 				t.Setenv("GIT_CONFIG_NOSYSTEM", tc.value)
 				dir := localScope(t, cleanConfig)
-				if got := needsGitFallback(dir, dir, "origin"); got != tc.want {
-					t.Errorf("needsGitFallback() = %v, want %v", got, tc.want)
+				if got := hasRewriteAToB(scopeRewrites(t, dir)); got != tc.want {
+					t.Errorf("system config read = %v, want %v", got, tc.want)
 				}
 			})
 		}
@@ -1181,11 +1711,11 @@ func TestNeedsGitFallback(t *testing.T) {
 		t.Setenv("XDG_CONFIG_HOME", "")
 		t.Setenv("GIT_CONFIG_GLOBAL", "")
 		mkdirAll(t, filepath.Join(home, ".config", "git"))
-		writeFile(t, filepath.Join(home, ".config", "git", "config"), "[url \"a\"]\n\tinsteadOf = b\n")
+		writeFile(t, filepath.Join(home, ".config", "git", "config"), rewriteAToB)
 
 		dir := localScope(t, cleanConfig)
-		if !needsGitFallback(dir, dir, "origin") {
-			t.Error("needsGitFallback() = false, want true for an insteadOf in ~/.config/git/config")
+		if !hasRewriteAToB(scopeRewrites(t, dir)) {
+			t.Error("the insteadOf in ~/.config/git/config was not read")
 		}
 	})
 
@@ -1195,11 +1725,11 @@ func TestNeedsGitFallback(t *testing.T) {
 		t.Setenv("HOME", home)
 		t.Setenv("USERPROFILE", home)
 		t.Setenv("GIT_CONFIG_GLOBAL", "")
-		writeFile(t, filepath.Join(home, ".gitconfig"), "[url \"a\"]\n\tinsteadOf = b\n")
+		writeFile(t, filepath.Join(home, ".gitconfig"), rewriteAToB)
 
 		dir := localScope(t, cleanConfig)
-		if !needsGitFallback(dir, dir, "origin") {
-			t.Error("needsGitFallback() = false, want true for an insteadOf in ~/.gitconfig")
+		if !hasRewriteAToB(scopeRewrites(t, dir)) {
+			t.Error("the insteadOf in ~/.gitconfig was not read")
 		}
 	})
 }
@@ -1229,12 +1759,12 @@ func TestNeedsGitFallback_Includes(t *testing.T) {
 		}
 	})
 
-	t.Run("an include that defines insteadOf forces the fallback", func(t *testing.T) {
+	t.Run("an include that defines insteadOf is resolved", func(t *testing.T) {
 		home := t.TempDir()
-		writeFile(t, filepath.Join(home, "extra"), "[url \"a\"]\n\tinsteadOf = b\n")
+		writeFile(t, filepath.Join(home, "extra"), rewriteAToB)
 		dir := setup(t, home, "[include]\n\tpath = extra\n")
-		if !needsGitFallback(dir, dir, "origin") {
-			t.Error("needsGitFallback() = false, want true: the included file rewrites URLs")
+		if !hasRewriteAToB(scopeRewrites(t, dir)) {
+			t.Error("the included insteadOf was not collected")
 		}
 	})
 
@@ -1250,19 +1780,19 @@ func TestNeedsGitFallback_Includes(t *testing.T) {
 	t.Run("a nested include is followed", func(t *testing.T) {
 		home := t.TempDir()
 		writeFile(t, filepath.Join(home, "mid"), "[include]\n\tpath = deep\n")
-		writeFile(t, filepath.Join(home, "deep"), "[url \"a\"]\n\tinsteadOf = b\n")
+		writeFile(t, filepath.Join(home, "deep"), rewriteAToB)
 		dir := setup(t, home, "[include]\n\tpath = mid\n")
-		if !needsGitFallback(dir, dir, "origin") {
-			t.Error("needsGitFallback() = false, want true: the insteadOf is two includes down")
+		if !hasRewriteAToB(scopeRewrites(t, dir)) {
+			t.Error("the insteadOf two includes down was not collected")
 		}
 	})
 
 	t.Run("a ~ path is expanded", func(t *testing.T) {
 		home := t.TempDir()
-		writeFile(t, filepath.Join(home, "tilde"), "[url \"a\"]\n\tinsteadOf = b\n")
+		writeFile(t, filepath.Join(home, "tilde"), rewriteAToB)
 		dir := setup(t, home, "[include]\n\tpath = ~/tilde\n")
-		if !needsGitFallback(dir, dir, "origin") {
-			t.Error("needsGitFallback() = false, want true: ~/tilde holds an insteadOf")
+		if !hasRewriteAToB(scopeRewrites(t, dir)) {
+			t.Error("the insteadOf in ~/tilde was not collected")
 		}
 	})
 
@@ -1285,19 +1815,19 @@ func TestNeedsGitFallback_Includes(t *testing.T) {
 
 	t.Run("a subsectioned include is ignored, as git ignores it", func(t *testing.T) {
 		home := t.TempDir()
-		writeFile(t, filepath.Join(home, "extra"), "[url \"a\"]\n\tinsteadOf = b\n")
+		writeFile(t, filepath.Join(home, "extra"), rewriteAToB)
 		dir := setup(t, home, "[include \"x\"]\n\tpath = extra\n")
-		if needsGitFallback(dir, dir, "origin") {
-			t.Error("needsGitFallback() = true, want false: [include \"x\"] is not an include")
+		if hasRewriteAToB(scopeRewrites(t, dir)) {
+			t.Error("[include \"x\"] is not an include, its target must not be read")
 		}
 	})
 
 	t.Run("an unknown includeIf condition is always false in git", func(t *testing.T) {
 		home := t.TempDir()
-		writeFile(t, filepath.Join(home, "extra"), "[url \"a\"]\n\tinsteadOf = b\n")
+		writeFile(t, filepath.Join(home, "extra"), rewriteAToB)
 		dir := setup(t, home, "[includeIf \"nosuchcond:x\"]\n\tpath = extra\n")
-		if needsGitFallback(dir, dir, "origin") {
-			t.Error("needsGitFallback() = true, want false: git never applies an unknown condition")
+		if hasRewriteAToB(scopeRewrites(t, dir)) {
+			t.Error("git never applies an unknown condition, its target must not be read")
 		}
 	})
 
@@ -1306,18 +1836,18 @@ func TestNeedsGitFallback_Includes(t *testing.T) {
 	// condition does not match this repository.
 	t.Run("a gitdir condition that cannot match is skipped", func(t *testing.T) {
 		home := t.TempDir()
-		writeFile(t, filepath.Join(home, "work"), "[url \"a\"]\n\tinsteadOf = b\n")
+		writeFile(t, filepath.Join(home, "work"), rewriteAToB)
 		elsewhere := mkdirAll(t, filepath.Join(home, "elsewhere"))
 		dir := setup(t, home, "[includeIf \"gitdir:"+elsewhere+"/\"]\n\tpath = work\n")
-		if needsGitFallback(dir, dir, "origin") {
-			t.Error("needsGitFallback() = true, want false: the condition cannot match this gitdir")
+		if hasRewriteAToB(scopeRewrites(t, dir)) {
+			t.Error("the condition cannot match this gitdir, its target must not be read")
 		}
 	})
 
 	t.Run("a gitdir condition that matches is honoured", func(t *testing.T) {
 		pinConfigScope(t)
 		home := t.TempDir()
-		writeFile(t, filepath.Join(home, "work"), "[url \"a\"]\n\tinsteadOf = b\n")
+		writeFile(t, filepath.Join(home, "work"), rewriteAToB)
 		dir := localScope(t, cleanConfig)
 		t.Setenv("HOME", home)
 		t.Setenv("USERPROFILE", home)
@@ -1326,13 +1856,14 @@ func TestNeedsGitFallback_Includes(t *testing.T) {
 		// trailing separator that makes git append its implicit "**".
 		writeFile(t, filepath.Join(home, ".gitconfig"),
 			"[includeIf \"gitdir:"+filepath.Dir(realPath(t, dir))+"/\"]\n\tpath = work\n")
-		if !needsGitFallback(dir, dir, "origin") {
-			t.Error("needsGitFallback() = false, want true: the condition matches this gitdir")
+		if !hasRewriteAToB(scopeRewrites(t, dir)) {
+			t.Error("the condition matches this gitdir, its insteadOf must be collected")
 		}
 	})
 
 	// Only gitdir: is evaluated. The other forms are judged by what they would
-	// include, so a harmless target still keeps the fast path.
+	// include, so a harmless target still keeps the fast path, while a rewrite
+	// that may or may not apply has to defer.
 	t.Run("an unevaluated condition is judged by the file it would include", func(t *testing.T) {
 		for _, cond := range []string{"gitdir/i:/x/", "onbranch:main", "hasconfig:remote.*.url:https://x/**"} {
 			t.Run(cond, func(t *testing.T) {
@@ -1343,7 +1874,7 @@ func TestNeedsGitFallback_Includes(t *testing.T) {
 					t.Errorf("needsGitFallback() = true, want false for %q with a harmless target", cond)
 				}
 
-				writeFile(t, filepath.Join(home, "harmless"), "[url \"a\"]\n\tinsteadOf = b\n")
+				writeFile(t, filepath.Join(home, "harmless"), rewriteAToB)
 				if !needsGitFallback(dir, dir, "origin") {
 					t.Errorf("needsGitFallback() = false, want true for %q with an insteadOf target", cond)
 				}
@@ -1470,14 +2001,18 @@ func TestReadRepoContextFromDisk(t *testing.T) {
 		}
 	})
 
-	// The fast path must not answer when something in scope could rewrite the
-	// URL, even though the repository itself is perfectly ordinary.
-	t.Run("an insteadOf in scope sends the caller to git", func(t *testing.T) {
+	// The rewrite is applied to the raw configured URL before it is turned
+	// into a web URL, exactly as `git remote get-url` reports it.
+	t.Run("an insteadOf in scope is applied", func(t *testing.T) {
 		pinConfigScope(t)
 		t.Setenv("GIT_CONFIG_GLOBAL", writeConfig(t,
-			"[url \"git@github.com:\"]\n\tinsteadOf = https://github.com/\n"))
-		if _, err := readRepoContextFromDisk(root, "origin"); err == nil {
-			t.Error("expected an error when insteadOf is configured")
+			"[url \"https://gitlab.com/mirror/\"]\n\tinsteadOf = git@github.com:example/\n"))
+		ctx, err := readRepoContextFromDisk(root, "origin")
+		if err != nil {
+			t.Fatalf("readRepoContextFromDisk() error = %v", err)
+		}
+		if ctx.baseURL != "https://gitlab.com/mirror/repo" {
+			t.Errorf("baseURL = %q, want the rewritten URL", ctx.baseURL)
 		}
 	})
 }
@@ -1489,18 +2024,20 @@ func TestGetRepoContext_FallsBackToGit(t *testing.T) {
 	root := newTmpGitRepo(t)
 	runGit(t, root, "remote", "add", "origin", "https://github.com/example/repo.git")
 
-	// insteadOf is the reason needsGitFallback exists: verified against git
-	// 2.54, `git remote get-url` really does apply the rewrite, so reading the
-	// raw config would report a URL git never uses.
+	// An insteadOf behind a condition the scan cannot evaluate: git applies
+	// it, and the fast path cannot know whether to, so it must stand down
+	// rather than report the raw config.
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, "mirror"), rewriteToMirror)
 	t.Setenv("GIT_CONFIG_GLOBAL", writeConfig(t,
-		"[url \"https://gitlab.com/mirror/\"]\n\tinsteadOf = https://github.com/example/\n"))
+		"[includeIf \"hasconfig:remote.*.url:https://github.com/**\"]\n\tpath = "+filepath.Join(home, "mirror")+"\n"))
 
 	const rewritten = "https://gitlab.com/mirror/repo"
 	if got := gitOut(t, root, "remote", "get-url", "origin"); got != rewritten+".git" {
 		t.Fatalf("precondition: git remote get-url = %q, want the rewritten URL", got)
 	}
 	if _, err := readRepoContextFromDisk(root, "origin"); err == nil {
-		t.Fatal("precondition: the fast path should refuse a scope containing insteadOf")
+		t.Fatal("precondition: the fast path should refuse a conditional insteadOf it cannot evaluate")
 	}
 
 	got, err := getRepoContext(root, "origin")
@@ -1689,7 +2226,7 @@ func TestResolveTarget(t *testing.T) {
 // rewriteToMirror is the config body the includeIf fixtures pull in: if git
 // reads it, the remote resolves to https://gitlab.com/mirror/repo instead of
 // https://github.com/example/repo, so a wrong verdict on the condition shows up
-// as a URL divergence rather than a silent pass.
+// as a URL divergence rather than a silent pass, whichever way it goes.
 const rewriteToMirror = "[url \"https://gitlab.com/mirror/\"]\n\tinsteadOf = https://github.com/example/\n"
 
 // TestDifferential_FastPathMatchesGit is the core guarantee of this design:
@@ -1710,7 +2247,10 @@ func TestDifferential_FastPathMatchesGit(t *testing.T) {
 		// remote to, so a fixture built around a URL rewrite cannot quietly
 		// become one where nothing is rewritten.
 		wantGitURL string
-		build      func(t *testing.T) (targetPath string)
+		// wantForge, when set, pins the provider a gopen.<host>.type fixture
+		// must bind, so it cannot pass by both paths ignoring the setting.
+		wantForge string
+		build     func(t *testing.T) (targetPath string)
 	}{
 		{
 			name:   "plain repo, HTTPS remote, root",
@@ -2016,6 +2556,183 @@ func TestDifferential_FastPathMatchesGit(t *testing.T) {
 			},
 		},
 
+		// --- url.<base>.insteadOf ---
+		//
+		// Rewrites are applied by the fast path itself, so each of these has
+		// to come out exactly as `git remote get-url` reports it.
+		{
+			name:       "global insteadOf",
+			remote:     "origin",
+			wantGitURL: "https://gitlab.com/mirror/repo",
+			build: func(t *testing.T) string {
+				return repoWithGlobalConfig(t, func(string) string { return rewriteToMirror })
+			},
+		},
+		{
+			// The company-wide setup that motivated resolving rewrites: HTTPS
+			// remotes pushed and fetched over SSH.
+			name:       "global insteadOf turning HTTPS into SSH",
+			remote:     "origin",
+			wantGitURL: "https://github.com/example/repo",
+			build: func(t *testing.T) string {
+				return repoWithGlobalConfig(t, func(string) string {
+					return "[url \"git@github.com:\"]\n\tinsteadOf = https://github.com/\n"
+				})
+			},
+		},
+		{
+			name:       "the longest insteadOf prefix wins across scopes",
+			remote:     "origin",
+			wantGitURL: "https://gitlab.com/longest/repo",
+			build: func(t *testing.T) string {
+				root := repoWithGlobalConfig(t, func(string) string {
+					return "[url \"https://gitlab.com/short/\"]\n\tinsteadOf = https://github.com/\n"
+				})
+				runGit(t, root, "config", "url.https://gitlab.com/longest/.insteadOf", "https://github.com/example/")
+				return root
+			},
+		},
+		{
+			// Equal lengths: the base git met first wins, and a base is met
+			// where it first appears even if a later line adds to it.
+			name:       "a tie goes to the base met first",
+			remote:     "origin",
+			wantGitURL: "https://first.example/example/repo",
+			build: func(t *testing.T) string {
+				return repoWithGlobalConfig(t, func(string) string {
+					return "[url \"https://first.example/\"]\n\tinsteadOf = https://other/\n" +
+						"[url \"https://second.example/\"]\n\tinsteadOf = https://github.com/\n" +
+						"[url \"https://first.example/\"]\n\tinsteadOf = https://github.com/\n"
+				})
+			},
+		},
+		{
+			name:       "pushInsteadOf does not touch the fetch URL",
+			remote:     "origin",
+			wantGitURL: "https://github.com/example/repo",
+			build: func(t *testing.T) string {
+				return repoWithGlobalConfig(t, func(string) string {
+					return "[url \"https://gitlab.com/mirror/\"]\n\tpushInsteadOf = https://github.com/example/\n"
+				})
+			},
+		},
+		{
+			name:       "insteadOf in the repository config",
+			remote:     "origin",
+			wantGitURL: "https://gitlab.com/mirror/repo",
+			build: func(t *testing.T) string {
+				root := newTmpGitRepo(t)
+				runGit(t, root, "remote", "add", "origin", "https://github.com/example/repo.git")
+				appendFile(t, filepath.Join(root, ".git", "config"), rewriteToMirror)
+				return root
+			},
+		},
+
+		// --- gopen.<host>.type ---
+		{
+			name:      "host type in the global config",
+			remote:    "origin",
+			wantForge: "gitlab",
+			build: func(t *testing.T) string {
+				root := repoWithGlobalConfig(t, func(string) string {
+					return "[gopen \"code.corp.example\"]\n\ttype = gitlab\n"
+				})
+				runGit(t, root, "remote", "set-url", "origin", "https://code.corp.example/team/repo.git")
+				return root
+			},
+		},
+		{
+			name:      "the repository config overrides a global host type",
+			remote:    "origin",
+			wantForge: "gitea",
+			build: func(t *testing.T) string {
+				root := repoWithGlobalConfig(t, func(string) string {
+					return "[gopen \"code.corp.example\"]\n\ttype = gitlab\n"
+				})
+				runGit(t, root, "remote", "set-url", "origin", "git@code.corp.example:team/repo.git")
+				runGit(t, root, "config", "gopen.code.corp.example.type", "gitea")
+				return root
+			},
+		},
+		{
+			// Host names have no case and the port is optional in the key.
+			name:      "host type matched without case or port",
+			remote:    "origin",
+			wantForge: "gitlab",
+			build: func(t *testing.T) string {
+				root := newTmpGitRepo(t)
+				runGit(t, root, "remote", "add", "origin", "https://code.corp.example:8443/team/repo.git")
+				appendFile(t, filepath.Join(root, ".git", "config"), "[gopen \"Code.Corp.Example\"]\n\ttype = GitLab\n")
+				return root
+			},
+		},
+		{
+			name:   "host type for another host",
+			remote: "origin",
+			build: func(t *testing.T) string {
+				root := newTmpGitRepo(t)
+				runGit(t, root, "remote", "add", "origin", "https://github.com/example/repo.git")
+				runGit(t, root, "config", "gopen.code.corp.example.type", "gitlab")
+				return root
+			},
+		},
+
+		// --- provider-specific clone URLs ---
+		{
+			name:      "Bitbucket Server SSH remote",
+			remote:    "origin",
+			wantForge: "bitbucket-server",
+			build: func(t *testing.T) string {
+				root := newTmpGitRepo(t)
+				runGit(t, root, "remote", "add", "origin", "ssh://git@bitbucket.corp.example:7999/key/repo.git")
+				return root
+			},
+		},
+
+		{
+			name:      "Azure DevOps SSH remote",
+			remote:    "origin",
+			wantForge: "azure",
+			build: func(t *testing.T) string {
+				root := newTmpGitRepo(t)
+				runGit(t, root, "remote", "add", "origin", "git@ssh.dev.azure.com:v3/org/project/repo")
+				return root
+			},
+		},
+
+		{
+			name:      "CodeCommit git-remote-codecommit remote",
+			remote:    "origin",
+			wantForge: "codecommit",
+			build: func(t *testing.T) string {
+				root := newTmpGitRepo(t)
+				runGit(t, root, "remote", "add", "origin", "codecommit::eu-west-1://profile@repo")
+				return root
+			},
+		},
+
+		{
+			name:      "Gerrit SSH remote",
+			remote:    "origin",
+			wantForge: "gitiles",
+			build: func(t *testing.T) string {
+				root := newTmpGitRepo(t)
+				runGit(t, root, "remote", "add", "origin", "ssh://jdoe@gerrit.corp.example:29418/platform/build")
+				return root
+			},
+		},
+
+		{
+			name:      "kernel.org cgit remote keeps .git",
+			remote:    "origin",
+			wantForge: "cgit",
+			build: func(t *testing.T) string {
+				root := newTmpGitRepo(t)
+				runGit(t, root, "remote", "add", "origin", "git://git.kernel.org/pub/scm/git/git.git")
+				return root
+			},
+		},
+
 		// --- include and includeIf ---
 		//
 		// These pin the whole point of resolving includes instead of refusing
@@ -2057,7 +2774,6 @@ func TestDifferential_FastPathMatchesGit(t *testing.T) {
 		{
 			name:       "include that defines insteadOf",
 			remote:     "origin",
-			fallsBack:  true,
 			wantGitURL: "https://gitlab.com/mirror/repo",
 			build: func(t *testing.T) string {
 				return repoWithGlobalConfig(t, func(home string) string {
@@ -2083,7 +2799,6 @@ func TestDifferential_FastPathMatchesGit(t *testing.T) {
 		{
 			name:       "nested include reaching an insteadOf",
 			remote:     "origin",
-			fallsBack:  true,
 			wantGitURL: "https://gitlab.com/mirror/repo",
 			build: func(t *testing.T) string {
 				return repoWithGlobalConfig(t, func(home string) string {
@@ -2122,10 +2837,9 @@ func TestDifferential_FastPathMatchesGit(t *testing.T) {
 		},
 		{
 			// The mirror image: the same rewrite, but a condition that does
-			// match, so git applies it and the fast path must stand down.
+			// match, so git applies it and the fast path must apply it too.
 			name:       "includeIf gitdir that matches",
 			remote:     "origin",
-			fallsBack:  true,
 			wantGitURL: "https://gitlab.com/mirror/repo",
 			build: func(t *testing.T) string {
 				return repoWithGlobalConfig(t, func(home string) string {
@@ -2142,7 +2856,6 @@ func TestDifferential_FastPathMatchesGit(t *testing.T) {
 		{
 			name:       "includeIf gitdir naming the git directory exactly",
 			remote:     "origin",
-			fallsBack:  true,
 			wantGitURL: "https://gitlab.com/mirror/repo",
 			build: func(t *testing.T) string {
 				return repoWithGlobalConfigFor(t, func(home, gitDir string) string {
@@ -2156,7 +2869,6 @@ func TestDifferential_FastPathMatchesGit(t *testing.T) {
 			// so the parent directory covers the git dir beneath it.
 			name:       "includeIf gitdir with a trailing separator matches a parent",
 			remote:     "origin",
-			fallsBack:  true,
 			wantGitURL: "https://gitlab.com/mirror/repo",
 			build: func(t *testing.T) string {
 				return repoWithGlobalConfigFor(t, func(home, gitDir string) string {
@@ -2221,7 +2933,6 @@ func TestDifferential_FastPathMatchesGit(t *testing.T) {
 			// symlink-resolved home, which is what git compares.
 			name:       "includeIf gitdir rooted at ~",
 			remote:     "origin",
-			fallsBack:  true,
 			wantGitURL: "https://gitlab.com/mirror/repo",
 			build: func(t *testing.T) string {
 				home := t.TempDir()
@@ -2262,6 +2973,22 @@ func TestDifferential_FastPathMatchesGit(t *testing.T) {
 				return root
 			},
 		},
+
+		// Reftable ref storage: HEAD and the branch are read from the stack.
+		{
+			name:   "reftable repository",
+			remote: "origin",
+			build: func(t *testing.T) string {
+				root := t.TempDir()
+				if err := tryGit(root, "init", "--ref-format=reftable", "."); err != nil {
+					t.Skipf("git does not support --ref-format=reftable: %v", err)
+				}
+				runGit(t, root, "-c", "user.email=test@test.com", "-c", "user.name=Test", "commit", "--allow-empty", "-m", "init")
+				runGit(t, root, "checkout", "-q", "-b", "feature/x")
+				runGit(t, root, "remote", "add", "origin", "https://github.com/example/repo.git")
+				return root
+			},
+		},
 	}
 
 	for _, f := range fixtures {
@@ -2276,6 +3003,10 @@ func TestDifferential_FastPathMatchesGit(t *testing.T) {
 				t.Fatalf("precondition: git resolves the remote to %q, want %q (err=%v)",
 					slow.baseURL, f.wantGitURL, slowErr)
 			}
+			if f.wantForge != "" && slow.forge != f.wantForge {
+				t.Fatalf("precondition: git path binds provider %q, want %q (err=%v)",
+					slow.forge, f.wantForge, slowErr)
+			}
 
 			if f.fallsBack {
 				if fastErr == nil {
@@ -2299,6 +3030,10 @@ func TestDifferential_FastPathMatchesGit(t *testing.T) {
 			if fastErr != nil || slowErr != nil {
 				t.Fatalf("both paths must resolve this shape:\n  fast path: %v\n  git path:  %v", fastErr, slowErr)
 			}
+			if fast.reader != readerGo || slow.reader != readerGit {
+				t.Errorf("readers = %q, %q, want %q, %q", fast.reader, slow.reader, readerGo, readerGit)
+			}
+			fast.reader, slow.reader = "", ""
 			if fast != slow {
 				t.Errorf("fast path diverges from git:\n  fast: %+v\n  git:  %+v", fast, slow)
 			}
@@ -2414,6 +3149,16 @@ func TestDifferential_ErrorCases(t *testing.T) {
 				return root
 			},
 		},
+		{
+			name:   "gopen.<host>.type naming no provider",
+			remote: "origin",
+			build: func(t *testing.T) string {
+				root := newTmpGitRepo(t)
+				runGit(t, root, "remote", "add", "origin", "https://code.corp.example/team/repo.git")
+				runGit(t, root, "config", "gopen.code.corp.example.type", "gitlabb")
+				return root
+			},
+		},
 		{
 			// The same, on the remote actually being asked for.
 			name:   "valueless url on the remote under lookup",
@@ -2467,7 +3212,7 @@ func newTmpSubmoduleWithRemote(t *testing.T) (super, sub string) {
 
 func BenchmarkGetRepoContext(b *testing.B) {
 	// Without this the benchmark silently measures the fallback twice: a real
-	// ~/.gitconfig containing includeIf (or insteadOf) disqualifies the fast
+	// ~/.gitconfig containing an includeIf it cannot evaluate disqualifies the fast
 	// path for every repository on the machine.
 	pinConfigScope(b)
 
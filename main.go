@@ -1,8 +1,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 )
 
 var (
@@ -33,36 +35,300 @@ func main() {
 		os.Exit(0)
 	}
 
-	targetPath, err := resolvePath(cfg.paths)
+	if cfg.command == "resolve" {
+		if err := runResolve(cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if cfg.stdin {
+		if err := runStdin(cfg, os.Stdin); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	var pages []page
+	switch cfg.command {
+	case "pr", "compare":
+		var (
+			targetPath string
+			ctx        repoContext
+			webURL     string
+		)
+		if targetPath, err = resolvePath(cfg.paths); err == nil {
+			if ctx, err = branchContext(cfg, targetPath); err == nil {
+				if cfg.command == "pr" {
+					webURL, err = buildChangeRequestURL(ctx)
+				} else {
+					webURL, err = compareURL(cfg, targetPath, ctx)
+				}
+			}
+		}
+		pages = []page{{url: webURL, ctx: ctx}}
+	default:
+		pages, err = pathPages(cfg, printWarning)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
-	ctx, err := getRepoContext(targetPath, cfg.remoteName)
-	if err != nil {
+	if err := deliverPages(cfg, pages); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
+}
 
-	webURL := buildWebURL(ctx, cfg.line, cfg.commit)
+// deliverPages prints, copies or opens the URLs of pages, one or many.
+//
+// -p wins over -c: printing is the scriptable, side-effect-free mode, so
+// the more conservative one takes precedence when both are given. Many URLs
+// are copied as one newline-separated text, and opened one tab each, past
+// the gopen.maxTabs cap only once confirmed. With --format the pages are
+// printed, or copied, in that format instead, and nothing is opened.
+func deliverPages(cfg config, pages []page) error {
+	if cfg.format != "" {
+		text, html, err := formatPages(cfg.format, pages)
+		if err != nil {
+			return err
+		}
+		if cfg.copy && !cfg.print {
+			// A link pasted into a chat or a document should be a link, so the
+			// clipboard gets its HTML too wherever it can hold both.
+			if err := copyRichToClipboard(strings.TrimSuffix(text, "\n"), html); err != nil {
+				return fmt.Errorf("copying to clipboard: %w", err)
+			}
+			fmt.Print("Copied to clipboard:\n" + text)
+			return nil
+		}
+		fmt.Print(text)
+		return nil
+	}
 
-	// -p wins over -c: printing is the scriptable, side-effect-free mode, so
-	// the more conservative one takes precedence when both are given.
+	urls := urlsOf(pages)
 	switch {
 	case cfg.print:
-		fmt.Println(webURL)
+		for _, u := range urls {
+			fmt.Println(u)
+		}
 	case cfg.copy:
-		if err := copyToClipboard(webURL); err != nil {
-			fmt.Fprintf(os.Stderr, "Error copying to clipboard: %v\n", err)
-			os.Exit(1)
+		if err := copyToClipboard(strings.Join(urls, "\n")); err != nil {
+			return fmt.Errorf("copying to clipboard: %w", err)
+		}
+		if len(urls) == 1 {
+			fmt.Printf("URL copied to clipboard: %s\n", urls[0])
+		} else {
+			fmt.Printf("%d URLs copied to clipboard\n", len(urls))
 		}
-		fmt.Printf("URL copied to clipboard: %s\n", webURL)
 	default:
-		fmt.Printf("Opening: %s\n", webURL)
-		if err := openBrowser(webURL); err != nil {
-			fmt.Fprintf(os.Stderr, "Error opening browser: %v\n", err)
-			os.Exit(1)
+		maxTabs := defaultMaxTabs
+		if len(urls) > 1 {
+			var err error
+			if maxTabs, err = getMaxTabs(); err != nil {
+				return err
+			}
+		}
+		open := func(u string) error {
+			fmt.Printf("Opening: %s\n", u)
+			return openBrowser(u)
+		}
+		if err := openURLs(urls, maxTabs, open, confirmTabs); err != nil {
+			return fmt.Errorf("opening browser: %w", err)
+		}
+	}
+	return nil
+}
+
+// printWarning reports a problem that does not stop gopen.
+func printWarning(warning string) {
+	fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+}
+
+// viewContext is the repository side of the default command: the context of
+// the repository around targetPath, with --ref applied, and the commit to pin
+// the URL to, "" to link the branch. The warning, when not "", says HEAD is
+// not pushed; see pushedCommit.
+func viewContext(cfg config, targetPath string) (ctx repoContext, commitHash, warning string, err error) {
+	if cfg.ref != "" && (cfg.commit != "" || cfg.permalink) {
+		return repoContext{}, "", "", errors.New("--ref cannot be combined with --commit or --permalink")
+	}
+	if cfg.blame && cfg.history {
+		return repoContext{}, "", "", errors.New("--blame and --history cannot be combined")
+	}
+	if ctx, err = branchContext(cfg, targetPath); err != nil {
+		return repoContext{}, "", "", err
+	}
+	if cfg.ref != "" {
+		if ctx, err = refContext(cfg, targetPath, ctx); err != nil {
+			return repoContext{}, "", "", err
+		}
+	}
+
+	// --commit already names a commit; --permalink pins to HEAD's
+	// otherwise, so a link survives the branch moving on.
+	commitHash = cfg.commit
+	if commitHash == "" && cfg.permalink {
+		commitHash = ctx.commit
+	}
+	if cfg.commit == "" && cfg.ref == "" && ctx.branch != detachedHEAD {
+		if commitHash, warning, err = pushedCommit(cfg, targetPath, ctx, commitHash); err != nil {
+			return repoContext{}, "", "", err
+		}
+	}
+	return ctx, commitHash, warning, nil
+}
+
+// refContext points ctx at the branch or tag --ref names. A remote-tracking
+// branch lives on its own remote, so the URL is built from that remote's
+// rather than the one ctx was read for; naming another remote with -r is an
+// error.
+func refContext(cfg config, targetPath string, ctx repoContext) (repoContext, error) {
+	ref, kind, remote, err := getRef(targetPath, cfg.ref)
+	if err != nil {
+		return repoContext{}, err
+	}
+	if remote != "" && remote != ctx.remote {
+		if cfg.remoteSet {
+			return repoContext{}, fmt.Errorf("--ref %s is a branch of %s, not of the -r remote %s", cfg.ref, remote, cfg.remoteName)
+		}
+		if ctx, err = getRepoContext(targetPath, remote); err != nil {
+			return repoContext{}, err
+		}
+	}
+	ctx.branch, ctx.refKind = ref, kind
+	return ctx, nil
+}
+
+// pageURL builds the page the default command opens for targetPath, whose
+// repository ctx describes: its blame, its history, or the path itself.
+func pageURL(cfg config, ctx repoContext, targetPath, line, commitHash string) (string, error) {
+	switch {
+	case cfg.blame:
+		// relPath alone cannot tell a directory from a file, and a
+		// directory has no blame page on any forge.
+		if info, err := os.Stat(targetPath); err == nil && info.IsDir() {
+			return "", fmt.Errorf("--blame needs a file, %s is a directory", targetPath)
+		}
+		return buildBlameURL(ctx, line, commitHash)
+	case cfg.history:
+		return buildHistoryURL(ctx, commitHash)
+	default:
+		return buildWebURL(ctx, line, commitHash), nil
+	}
+}
+
+// branchContext is getRepoContext with the branch named as its upstream names
+// it: a local feature tracking origin/jsmith/feature opens jsmith/feature,
+// which is what exists on the forge. Without -r the upstream's remote is used;
+// with it, the upstream only counts when it is on that remote. compare looks
+// the branch up where it is pushed instead, so it keeps the local name, as
+// does --no-upstream.
+func branchContext(cfg config, targetPath string) (repoContext, error) {
+	ctx, err := getRepoContext(targetPath, cfg.remoteName)
+	if err != nil || cfg.noUpstream || cfg.command == "compare" || ctx.branch == detachedHEAD {
+		return ctx, err
+	}
+	remote, remoteBranch, err := getUpstream(targetPath, ctx.branch)
+	if err != nil || remote == "" {
+		return ctx, err
+	}
+	if remote != cfg.remoteName {
+		if cfg.remoteSet {
+			return ctx, nil
+		}
+		if ctx, err = getRepoContext(targetPath, remote); err != nil {
+			return repoContext{}, err
+		}
+	}
+	ctx.branch = remoteBranch
+	return ctx, nil
+}
+
+// pushedCommit checks that what a URL built from HEAD names is on the remote.
+// A branch the remote does not have is a dead link, and so is a permalink to a
+// commit it never received; both fall back to the last pushed commit, the
+// merge base of HEAD and the remote branch, or of HEAD and the remote's
+// default branch when the branch was never pushed. A branch that is only ahead
+// still opens, since the page exists. Either way a warning says so, and
+// --strict makes it an error instead.
+//
+// It returns the commit to pin the URL to, commitHash unless it falls back,
+// and the warning to print, "" when HEAD is pushed.
+func pushedCommit(cfg config, targetPath string, ctx repoContext, commitHash string) (string, string, error) {
+	tracking, found, err := getTrackingCommit(targetPath, ctx.remote, ctx.branch)
+	if err != nil {
+		return "", "", err
+	}
+	if found && tracking == ctx.commit {
+		return commitHash, "", nil
+	}
+
+	base := tracking
+	if !found {
+		// What the branch was started from may well have been pushed.
+		if defaultBranch, err := getRemoteHEAD(targetPath, ctx.remote); err == nil {
+			if base, _, err = getTrackingCommit(targetPath, ctx.remote, defaultBranch); err != nil {
+				return "", "", err
+			}
+		}
+	}
+	var pushed string
+	if base != "" {
+		if pushed, err = getMergeBase(targetPath, ctx.commit, base); err != nil {
+			return "", "", err
+		}
+	}
+	if found && pushed == ctx.commit {
+		// Behind the remote branch, which already has HEAD.
+		return commitHash, "", nil
+	}
+
+	problem := fmt.Sprintf("branch %s is not on %s", ctx.branch, ctx.remote)
+	if found {
+		problem = fmt.Sprintf("HEAD %.7s is not on %s/%s", ctx.commit, ctx.remote, ctx.branch)
+	}
+	if cfg.strict {
+		return "", "", fmt.Errorf("%s; push it first", problem)
+	}
+	if pushed != "" && (!found || commitHash != "") {
+		return pushed, fmt.Sprintf("%s; linking the last pushed commit, %.7s", problem, pushed), nil
+	}
+	return commitHash, problem + "; the page will not show it until you push", nil
+}
+
+// compareURL resolves what `gopen compare` needs beyond ctx: the base branch,
+// defaulting to the remote's own default, and the remote the current branch is
+// pushed to. When that is not the -r remote, the branch lives in a fork and
+// the compare is opened on the -r remote against it.
+func compareURL(cfg config, targetPath string, ctx repoContext) (string, error) {
+	if ctx.branch == detachedHEAD {
+		return buildCompareURL(ctx, "", "")
+	}
+
+	base := cfg.base
+	if base == "" {
+		var err error
+		if base, err = getRemoteHEAD(targetPath, cfg.remoteName); err != nil {
+			return "", err
+		}
+	}
+
+	pushRemote, err := getPushRemote(targetPath, ctx.branch)
+	if err != nil {
+		return "", err
+	}
+	var forkBase string
+	if pushRemote != cfg.remoteName {
+		// A push remote that does not exist (the implicit "origin" in a
+		// clone that only has "upstream") means the branch has nowhere
+		// else to live: compare within the -r remote.
+		if fork, err := getRepoContext(targetPath, pushRemote); err == nil {
+			forkBase = fork.baseURL
 		}
 	}
+	return buildCompareURL(ctx, base, forkBase)
 }
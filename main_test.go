@@ -20,3 +20,148 @@ func TestVersionFlag(t *testing.T) {
 		t.Errorf("unexpected output: %q", string(out))
 	}
 }
+
+func TestPushedCommit(t *testing.T) {
+	// Each setup starts on branch feature, one commit past "base", with
+	// origin configured but nothing fetched.
+	tests := []struct {
+		name        string
+		setup       [][]string
+		permalink   bool
+		strict      bool
+		want        string // "base" for that commit, "" for commitHash unchanged
+		wantWarning bool
+		wantErr     bool
+	}{
+		{name: "pushed", setup: [][]string{{"update-ref", "refs/remotes/origin/feature", "HEAD"}}},
+		{
+			name: "behind the remote branch",
+			setup: [][]string{
+				{"checkout", "-q", "-b", "later"},
+				{"commit", "-q", "--allow-empty", "-m", "later"},
+				{"update-ref", "refs/remotes/origin/feature", "later"},
+				{"checkout", "-q", "feature"},
+			},
+		},
+		{
+			name:        "ahead opens the branch",
+			setup:       [][]string{{"update-ref", "refs/remotes/origin/feature", "base"}},
+			wantWarning: true,
+		},
+		{
+			name:        "ahead pins a permalink to the last pushed commit",
+			setup:       [][]string{{"update-ref", "refs/remotes/origin/feature", "base"}},
+			permalink:   true,
+			want:        "base",
+			wantWarning: true,
+		},
+		{
+			name: "never pushed falls back to the default branch's merge base",
+			setup: [][]string{
+				{"update-ref", "refs/remotes/origin/main", "ahead"},
+				{"symbolic-ref", "refs/remotes/origin/HEAD", "refs/remotes/origin/main"},
+			},
+			want:        "base",
+			wantWarning: true,
+		},
+		{name: "never pushed and no default branch", wantWarning: true},
+		{
+			name:    "strict",
+			setup:   [][]string{{"update-ref", "refs/remotes/origin/feature", "base"}},
+			strict:  true,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newTmpGitRepo(t)
+			runGit(t, dir, "remote", "add", "origin", "https://github.com/user/repo")
+			runGit(t, dir, "branch", "base")
+			// "ahead" is a descendant of base that HEAD is not.
+			runGit(t, dir, "checkout", "-q", "-b", "ahead")
+			runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "ahead")
+			runGit(t, dir, "checkout", "-q", "-b", "feature", "base")
+			runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "feature")
+			for _, args := range tt.setup {
+				runGit(t, dir, args...)
+			}
+
+			ctx, err := getRepoContext(dir, "origin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var commitHash string
+			if tt.permalink {
+				commitHash = ctx.commit
+			}
+			got, warning, err := pushedCommit(config{strict: tt.strict}, dir, ctx, commitHash)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("pushedCommit() = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := commitHash
+			if tt.want == "base" {
+				want = gitOut(t, dir, "rev-parse", "base")
+			}
+			if got != want {
+				t.Errorf("pushedCommit() = %q, want %q", got, want)
+			}
+			if (warning != "") != tt.wantWarning {
+				t.Errorf("pushedCommit() warning = %q, want one: %v", warning, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestViewContextRef(t *testing.T) {
+	// origin and upstream are different repositories; each --ref must open
+	// the one its branch lives on.
+	tests := []struct {
+		name     string
+		remote   string // -r
+		ref      string
+		wantURL  string
+		wantBase string
+		wantErr  bool
+	}{
+		{name: "branch of another remote", ref: "upstream/only-upstream", wantURL: "https://gitlab.com/org/repo", wantBase: "only-upstream"},
+		{name: "branch of the default remote", ref: "origin/main", wantURL: "https://github.com/user/repo", wantBase: "main"},
+		{name: "local branch keeps the -r remote", remote: "upstream", ref: "main", wantURL: "https://gitlab.com/org/repo", wantBase: "main"},
+		{name: "-r matches the ref's remote", remote: "upstream", ref: "upstream/only-upstream", wantURL: "https://gitlab.com/org/repo", wantBase: "only-upstream"},
+		{name: "-r names another remote", remote: "upstream", ref: "origin/main", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newTmpGitRepo(t)
+			runGit(t, dir, "branch", "-M", "main")
+			runGit(t, dir, "remote", "add", "origin", "https://github.com/user/repo.git")
+			runGit(t, dir, "remote", "add", "upstream", "https://gitlab.com/org/repo.git")
+			runGit(t, dir, "update-ref", "refs/remotes/origin/main", "HEAD")
+			runGit(t, dir, "update-ref", "refs/remotes/upstream/only-upstream", "HEAD")
+
+			cfg := config{remoteName: "origin", ref: tt.ref}
+			if tt.remote != "" {
+				cfg.remoteName, cfg.remoteSet = tt.remote, true
+			}
+			ctx, _, _, err := viewContext(cfg, dir)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("viewContext() = %+v, want an error", ctx)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ctx.baseURL != tt.wantURL || ctx.branch != tt.wantBase || ctx.refKind != refBranch {
+				t.Errorf("viewContext() = (%q, %q, %v), want (%q, %q, refBranch)", ctx.baseURL, ctx.branch, ctx.refKind, tt.wantURL, tt.wantBase)
+			}
+		})
+	}
+}
@@ -1,12 +1,56 @@
 package main
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
 	"runtime"
+	"strings"
 )
 
+// defaultMaxTabs is how many tabs gopen opens at once without asking, unless
+// gopen.maxTabs says otherwise.
+const defaultMaxTabs = 10
+
+// openURLs opens each of urls in the browser with open, in order. More than
+// maxTabs of them are only opened once confirm agrees; declining opens none.
+func openURLs(urls []string, maxTabs int, open func(string) error, confirm func(n, maxTabs int) (bool, error)) error {
+	if len(urls) > 1 && len(urls) > maxTabs {
+		ok, err := confirm(len(urls), maxTabs)
+		if err != nil || !ok {
+			return err
+		}
+	}
+	for _, u := range urls {
+		if err := open(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// confirmTabs asks on the terminal whether to open n tabs, more than maxTabs.
+// Without a terminal there is no one to ask, and that is an error rather than
+// a silent yes or no.
+func confirmTabs(n, maxTabs int) (bool, error) {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false, fmt.Errorf("%d tabs is more than gopen.maxTabs (%d) and there is no terminal to confirm on; use -p, or raise gopen.maxTabs", n, maxTabs)
+	}
+	return askYesNo(os.Stdin, os.Stderr, fmt.Sprintf("Open %d browser tabs?", n)), nil
+}
+
+// askYesNo writes question to w and reads the answer from r. Only y or yes,
+// in any case, is a yes.
+func askYesNo(r io.Reader, w io.Writer, question string) bool {
+	fmt.Fprintf(w, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(r).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
 func openBrowser(url string) error {
 	cmd, err := buildOpenCmd(url, runtime.GOOS)
 	if err != nil {
@@ -50,6 +94,26 @@ func copyToClipboard(text string) error {
 	return cmd.Wait()
 }
 
+// copyRichToClipboard copies text, and html as the rich-text flavour of the
+// same content where the clipboard can be given both at once, so a paste into
+// a text field gets text and one into a document gets HTML. That is macOS,
+// through osascript; wl-copy, xclip, xsel and clip take one flavour per call,
+// so elsewhere only text is copied. html may be "" for text alone.
+func copyRichToClipboard(text, html string) error {
+	if html == "" || runtime.GOOS != "darwin" {
+		return copyToClipboard(text)
+	}
+	return buildRichClipboardCmd(text, html).Run()
+}
+
+// buildRichClipboardCmd returns the macOS command setting the clipboard to
+// text and html together. Both travel as raw data literals, hex-encoded, so
+// no quoting in either can break out of the AppleScript.
+func buildRichClipboardCmd(text, html string) *exec.Cmd {
+	script := fmt.Sprintf("set the clipboard to {«class utf8»:«data utf8%X», «class HTML»:«data HTML%X»}", text, html)
+	return exec.Command("osascript", "-e", script)
+}
+
 // buildClipboardCmd returns the OS-appropriate command to write to the clipboard via stdin.
 // lookPath is injected to allow testing the Linux fallback chain without system dependencies.
 func buildClipboardCmd(goos string, lookPath func(string) (string, error)) (*exec.Cmd, error) {
@@ -71,3 +135,32 @@ func buildClipboardCmd(goos string, lookPath func(string) (string, error)) (*exe
 		return nil, fmt.Errorf("unsupported platform: %s", goos)
 	}
 }
+
+// openEditor opens path in $VISUAL, else $EDITOR, at line when one is given.
+func openEditor(path, line string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	cmd, err := buildEditorCmd(editor, path, line)
+	if err != nil {
+		return err
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// buildEditorCmd returns the command opening path at line in editor, which may
+// carry its own arguments ("code -w"). "+<line>" is the convention vi, Emacs,
+// nano and most terminal editors share.
+func buildEditorCmd(editor, path, line string) (*exec.Cmd, error) {
+	fields := strings.Fields(editor)
+	if len(fields) == 0 {
+		return nil, errors.New("no editor: set $VISUAL or $EDITOR")
+	}
+	args := fields[1:]
+	if line != "" {
+		args = append(args, "+"+line)
+	}
+	return exec.Command(fields[0], append(args, path)...), nil
+}
@@ -94,3 +94,107 @@ func TestBuildClipboardCmd(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildEditorCmd(t *testing.T) {
+	tests := []struct {
+		name     string
+		editor   string
+		line     string
+		wantArgs []string
+		wantErr  bool
+	}{
+		{name: "at a line", editor: "vim", line: "42", wantArgs: []string{"vim", "+42", "app.go"}},
+		{name: "no line", editor: "nano", wantArgs: []string{"nano", "app.go"}},
+		{name: "editor with its own flags", editor: "emacsclient -nw", line: "7", wantArgs: []string{"emacsclient", "-nw", "+7", "app.go"}},
+		{name: "no editor", editor: " ", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, err := buildEditorCmd(tt.editor, "app.go", tt.line)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("buildEditorCmd(%q) = %v, want an error", tt.editor, cmd.Args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("buildEditorCmd(%q) error = %v", tt.editor, err)
+			}
+			if strings.Join(cmd.Args, " ") != strings.Join(tt.wantArgs, " ") {
+				t.Errorf("buildEditorCmd(%q).Args = %q, want %q", tt.editor, cmd.Args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestOpenURLs(t *testing.T) {
+	urls := []string{"https://a", "https://b", "https://c"}
+	tests := []struct {
+		name       string
+		urls       []string
+		maxTabs    int
+		answer     bool
+		answerErr  error
+		wantAsked  bool
+		wantOpened []string
+		wantErr    bool
+	}{
+		{name: "within the cap", urls: urls, maxTabs: 3, wantOpened: urls},
+		{name: "above the cap, confirmed", urls: urls, maxTabs: 2, answer: true, wantAsked: true, wantOpened: urls},
+		{name: "above the cap, declined", urls: urls, maxTabs: 2, wantAsked: true},
+		{name: "above the cap, nobody to ask", urls: urls, maxTabs: 2, answerErr: errors.New("no terminal"), wantAsked: true, wantErr: true},
+		{name: "one tab is never asked about", urls: urls[:1], maxTabs: 0, wantOpened: urls[:1]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opened []string
+			asked := false
+			err := openURLs(tt.urls, tt.maxTabs,
+				func(u string) error { opened = append(opened, u); return nil },
+				func(n, maxTabs int) (bool, error) {
+					asked = true
+					if n != len(tt.urls) || maxTabs != tt.maxTabs {
+						t.Errorf("confirm(%d, %d), want (%d, %d)", n, maxTabs, len(tt.urls), tt.maxTabs)
+					}
+					return tt.answer, tt.answerErr
+				})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("openURLs error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if asked != tt.wantAsked {
+				t.Errorf("asked = %v, want %v", asked, tt.wantAsked)
+			}
+			if strings.Join(opened, " ") != strings.Join(tt.wantOpened, " ") {
+				t.Errorf("opened %q, want %q", opened, tt.wantOpened)
+			}
+		})
+	}
+}
+
+func TestAskYesNo(t *testing.T) {
+	for answer, want := range map[string]bool{
+		"y\n":   true,
+		"YES\n": true,
+		" y ":   true,
+		"\n":    false,
+		"n\n":   false,
+		"":      false,
+		"yep\n": false,
+	} {
+		var prompt strings.Builder
+		if got := askYesNo(strings.NewReader(answer), &prompt, "Open 12 browser tabs?"); got != want {
+			t.Errorf("askYesNo(%q) = %v, want %v", answer, got, want)
+		}
+		if prompt.String() != "Open 12 browser tabs? [y/N] " {
+			t.Errorf("prompt = %q", prompt.String())
+		}
+	}
+}
+
+func TestBuildRichClipboardCmd(t *testing.T) {
+	cmd := buildRichClipboardCmd(`say "hi"`, `<a href="x">`)
+	want := []string{"osascript", "-e", "set the clipboard to {«class utf8»:«data utf87361792022686922», «class HTML»:«data HTML3C6120687265663D2278223E»}"}
+	if strings.Join(cmd.Args, "\x00") != strings.Join(want, "\x00") {
+		t.Errorf("args = %q, want %q", cmd.Args, want)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// runResolve is `gopen resolve <url>`: it reads a page URL of one of the
+// repository's remotes back into a local path and line, and prints them as
+// path:line, relative to the working directory, or opens them in the editor.
+func runResolve(cfg config) error {
+	cwd, err := effectiveCwd()
+	if err != nil {
+		return err
+	}
+	remotes := []string{cfg.remoteName}
+	if !cfg.remoteSet {
+		if remotes, err = getRemoteNames(cwd); err != nil {
+			return err
+		}
+	}
+	ctx, loc, err := resolveURL(cwd, cfg.resolveURL, remotes)
+	if err != nil {
+		return err
+	}
+
+	path := localPath(ctx, loc.path)
+	if cfg.edit {
+		return openEditor(path, loc.start)
+	}
+	if loc.start != "" {
+		path += ":" + loc.start
+	}
+	fmt.Println(path)
+	return nil
+}
+
+// resolveURL finds the remote, among remotes, that rawURL is a page of and
+// reads the page back. A ref with a slash in it is told from the path after
+// it by the refs the repository has.
+func resolveURL(targetPath, rawURL string, remotes []string) (repoContext, webLocation, error) {
+	page, err := url.Parse(rawURL)
+	if err != nil || page.Host == "" {
+		return repoContext{}, webLocation{}, fmt.Errorf("%q is not a URL", rawURL)
+	}
+	for _, remote := range remotes {
+		// A remote gopen cannot build URLs for cannot have built this one.
+		ctx, err := getRepoContext(targetPath, remote)
+		if err != nil {
+			continue
+		}
+		loc, ok := providerFor(ctx).parsePage(ctx.baseURL, page)
+		if !ok {
+			continue
+		}
+		return ctx, loc.splitRef(func(name string) bool {
+			return isLocalRef(targetPath, remote, name)
+		}), nil
+	}
+	return repoContext{}, webLocation{}, fmt.Errorf("%s is not a page of any remote of this repository (%s)", rawURL, strings.Join(remotes, ", "))
+}
+
+// isLocalRef reports whether name is a branch or tag of the repository, or a
+// branch of remote as of the last fetch.
+func isLocalRef(targetPath, remote, name string) bool {
+	if _, found, err := getTrackingCommit(targetPath, remote, name); err == nil && found {
+		return true
+	}
+	_, _, _, err := getRef(targetPath, name)
+	return err == nil
+}
+
+// localPath returns path, relative to the repository root, relative to the
+// directory ctx was read from instead, which is ctx.relPath below the root.
+func localPath(ctx repoContext, path string) string {
+	rel, err := filepath.Rel(filepath.FromSlash(ctx.relPath), filepath.FromSlash(path))
+	if err != nil {
+		return filepath.FromSlash(path)
+	}
+	return rel
+}
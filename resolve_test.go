@@ -0,0 +1,90 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveURL(t *testing.T) {
+	dir := newTmpGitRepo(t)
+	runGit(t, dir, "remote", "add", "origin", "git@github.com:user/repo.git")
+	runGit(t, dir, "remote", "add", "upstream", "https://gitlab.com/org/repo.git")
+	runGit(t, dir, "update-ref", "refs/remotes/upstream/release/2.x", "HEAD")
+	remotes := []string{"origin", "upstream"}
+
+	tests := []struct {
+		name      string
+		url       string
+		wantBase  string
+		wantRef   string
+		wantPath  string
+		wantStart string
+		wantErr   bool
+	}{
+		{
+			name:      "file on origin",
+			url:       "https://github.com/user/repo/blob/main/src/app.go#L12-L20",
+			wantBase:  "https://github.com/user/repo",
+			wantRef:   "main",
+			wantPath:  "src/app.go",
+			wantStart: "12",
+		},
+		{
+			// The remote-tracking branch tells release/2.x from the path.
+			name:      "slashed branch on the second remote",
+			url:       "https://gitlab.com/org/repo/-/blob/release/2.x/src/app.go#L7",
+			wantBase:  "https://gitlab.com/org/repo",
+			wantRef:   "release/2.x",
+			wantPath:  "src/app.go",
+			wantStart: "7",
+		},
+		{
+			name:     "owner and repository in another case",
+			url:      "https://github.com/User/Repo/tree/main/docs",
+			wantBase: "https://github.com/user/repo",
+			wantRef:  "main",
+			wantPath: "docs",
+		},
+		{name: "another repository", url: "https://github.com/user/other/blob/main/app.go", wantErr: true},
+		{name: "not a URL", url: "src/app.go", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, loc, err := resolveURL(dir, tt.url, remotes)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("resolveURL(%q) = %+v, want an error", tt.url, loc)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveURL(%q) error = %v", tt.url, err)
+			}
+			if ctx.baseURL != tt.wantBase || loc.ref != tt.wantRef || loc.path != tt.wantPath || loc.start != tt.wantStart {
+				t.Errorf("resolveURL(%q) = (%s, %+v), want (%s, ref %q, path %q, start %q)",
+					tt.url, ctx.baseURL, loc, tt.wantBase, tt.wantRef, tt.wantPath, tt.wantStart)
+			}
+		})
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		relPath string
+		path    string
+		want    string
+	}{
+		{name: "from the root", path: "src/app.go", want: filepath.Join("src", "app.go")},
+		{name: "from the file's directory", relPath: "src", path: "src/app.go", want: "app.go"},
+		{name: "from a sibling directory", relPath: "docs/api", path: "src/app.go", want: filepath.Join("..", "..", "src", "app.go")},
+		{name: "the root itself", relPath: "src", want: ".."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := localPath(repoContext{relPath: tt.relPath}, tt.path); got != tt.want {
+				t.Errorf("localPath(%q, %q) = %q, want %q", tt.relPath, tt.path, got, tt.want)
+			}
+		})
+	}
+}
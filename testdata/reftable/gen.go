@@ -0,0 +1,293 @@
+//go:build ignore
+
+// gen writes the reftable fixtures in this directory. Object ids are the
+// leading bytes of the SHA-256 of a seed string, so the tests can name the
+// ref they expect ("main", "feature/x", ...) instead of a real commit.
+//
+//	go run ./testdata/reftable/gen.go          # rewrite the fixtures
+//	go run ./testdata/reftable/gen.go -check   # fail if they are out of date
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+)
+
+// Value types, from the low three bits of a ref record's suffix length.
+const (
+	deletion = 0
+	val1     = 1 // one object id
+	val2     = 2 // an annotated tag and the object it peels to
+	symref   = 3
+)
+
+type ref struct {
+	name   string
+	typ    int
+	value  [][]byte
+	update uint64
+}
+
+type table struct {
+	version     byte
+	blockSize   int
+	minUpdate   uint64
+	maxUpdate   uint64
+	hashID      string
+	restartEach int
+	pad         bool
+}
+
+func newTable() table {
+	return table{version: 1, blockSize: 4096, minUpdate: 1, maxUpdate: 1, restartEach: 16, pad: true}
+}
+
+// varint is the reftable varint: big-endian groups of seven bits, each
+// continuation group biased by one.
+func varint(v uint64) []byte {
+	out := []byte{byte(v & 0x7f)}
+	v >>= 7
+	for v != 0 {
+		v--
+		out = append(out, 0x80|byte(v&0x7f))
+		v >>= 7
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+func uint24(v int) []byte {
+	return []byte{byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+func (t table) header() []byte {
+	h := []byte("REFT")
+	h = append(h, t.version)
+	h = append(h, uint24(t.blockSize)...)
+	h = binary.BigEndian.AppendUint64(h, t.minUpdate)
+	h = binary.BigEndian.AppendUint64(h, t.maxUpdate)
+	if t.version == 2 {
+		h = append(h, t.hashID...)
+	}
+	return h
+}
+
+func (t table) record(prev string, r ref, restart bool) []byte {
+	prefix := 0
+	if !restart {
+		for prefix < len(prev) && prefix < len(r.name) && prev[prefix] == r.name[prefix] {
+			prefix++
+		}
+	}
+	suffix := r.name[prefix:]
+	b := varint(uint64(prefix))
+	b = append(b, varint(uint64(len(suffix)<<3|r.typ))...)
+	b = append(b, suffix...)
+	b = append(b, varint(r.update-t.minUpdate)...)
+	switch r.typ {
+	case val1, val2:
+		for _, v := range r.value {
+			b = append(b, v...)
+		}
+	case symref:
+		b = append(b, varint(uint64(len(r.value[0])))...)
+		b = append(b, r.value[0]...)
+	}
+	return b
+}
+
+// build lays the refs out in ref blocks, the first one sharing its block
+// with the file header, and appends a footer with no log, object or index
+// sections.
+func (t table) build(refs []ref) []byte {
+	sort.SliceStable(refs, func(i, j int) bool { return refs[i].name < refs[j].name })
+	hdr := t.header()
+	var out []byte
+	for i, first := 0, true; i < len(refs); first = false {
+		base := 0
+		if first {
+			base = len(hdr)
+		}
+		var body []byte
+		var restarts []int
+		prev := ""
+		for n := 0; i < len(refs); n++ {
+			restart := n%t.restartEach == 0
+			rec := t.record(prev, refs[i], restart)
+			nrestarts := len(restarts)
+			if restart {
+				nrestarts++
+			}
+			if n > 0 && base+4+len(body)+len(rec)+3*nrestarts+2 > t.blockSize {
+				break
+			}
+			if restart {
+				restarts = append(restarts, base+4+len(body))
+			}
+			body = append(body, rec...)
+			prev = refs[i].name
+			i++
+		}
+		var blk []byte
+		if first {
+			blk = append(blk, hdr...)
+		}
+		blockLen := base + 4 + len(body) + 3*len(restarts) + 2
+		blk = append(blk, 'r')
+		blk = append(blk, uint24(blockLen)...)
+		blk = append(blk, body...)
+		for _, r := range restarts {
+			blk = append(blk, uint24(r)...)
+		}
+		blk = binary.BigEndian.AppendUint16(blk, uint16(len(restarts)))
+		if t.pad {
+			blk = append(blk, make([]byte, t.blockSize-len(blk))...)
+		}
+		out = append(out, blk...)
+	}
+	if len(refs) == 0 {
+		out = append(out, hdr...)
+	}
+	footer := append(t.header(), make([]byte, 5*8)...)
+	footer = binary.BigEndian.AppendUint32(footer, crc32.ChecksumIEEE(footer))
+	return append(out, footer...)
+}
+
+func oid(seed string, n int) []byte {
+	sum := sha256.Sum256([]byte(seed))
+	return sum[:n]
+}
+
+func sha1ID(seed string) []byte { return oid(seed, 20) }
+
+func direct(name, seed string, update uint64) ref {
+	return ref{name, val1, [][]byte{sha1ID(seed)}, update}
+}
+
+func symbolic(name, target string, update uint64) ref {
+	return ref{name, symref, [][]byte{[]byte(target)}, update}
+}
+
+type fixture struct {
+	name   string
+	tables [][]byte
+}
+
+func fixtures() []fixture {
+	stackOld, stackNew := newTable(), newTable()
+	stackNew.minUpdate, stackNew.maxUpdate = 2, 2
+
+	branches := []ref{symbolic("HEAD", "refs/heads/branch-17", 1)}
+	for k := range 40 {
+		name := fmt.Sprintf("branch-%02d", k)
+		branches = append(branches, direct("refs/heads/"+name, name, 1))
+	}
+	small := newTable()
+	small.blockSize, small.restartEach = 256, 3
+	unpadded := small
+	unpadded.pad = false
+
+	v2 := newTable()
+	v2.version, v2.hashID = 2, "s256"
+
+	corrupt := newTable().build([]ref{
+		symbolic("HEAD", "refs/heads/main", 1),
+		direct("refs/heads/main", "main", 1),
+	})
+	corrupt[len(corrupt)-10] ^= 0xff
+
+	return []fixture{
+		{"basic", [][]byte{newTable().build([]ref{
+			symbolic("HEAD", "refs/heads/main", 1),
+			direct("refs/heads/main", "main", 1),
+			direct("refs/heads/feature/x", "feature/x", 1),
+			{"refs/tags/v1.0", val2, [][]byte{sha1ID("tag v1.0"), sha1ID("main")}, 1},
+			direct("refs/remotes/origin/main", "main", 1),
+		})}},
+		{"detached", [][]byte{newTable().build([]ref{
+			direct("HEAD", "detached", 1),
+			direct("refs/heads/main", "main", 1),
+		})}},
+		{"unborn", [][]byte{newTable().build([]ref{
+			symbolic("HEAD", "refs/heads/main", 1),
+		})}},
+		{"stack", [][]byte{
+			stackOld.build([]ref{
+				symbolic("HEAD", "refs/heads/gone", 1),
+				direct("refs/heads/gone", "gone", 1),
+				direct("refs/heads/main", "main", 1),
+				direct("refs/heads/kept", "kept", 1),
+			}),
+			stackNew.build([]ref{
+				symbolic("HEAD", "refs/heads/main", 2),
+				{"refs/heads/gone", deletion, nil, 2},
+				direct("refs/heads/main", "main v2", 2),
+			}),
+		}},
+		{"multiblock", [][]byte{small.build(append([]ref(nil), branches...))}},
+		{"unaligned", [][]byte{unpadded.build(append([]ref(nil), branches...))}},
+		{"sha256", [][]byte{v2.build([]ref{
+			symbolic("HEAD", "refs/heads/main", 1),
+			{"refs/heads/main", val1, [][]byte{oid("main", 32)}, 1},
+		})}},
+		{"worktree", [][]byte{newTable().build([]ref{
+			symbolic("HEAD", "refs/heads/feature/x", 1),
+		})}},
+		{"corrupt", [][]byte{corrupt}},
+	}
+}
+
+// files maps each path under dir to the bytes gen writes there.
+func files(dir string) map[string][]byte {
+	out := map[string][]byte{}
+	for _, f := range fixtures() {
+		var list bytes.Buffer
+		for i, data := range f.tables {
+			name := fmt.Sprintf("0x%012x-0x%012x-%08x.ref", i+1, i+1, crc32.ChecksumIEEE(data))
+			out[filepath.Join(dir, f.name, "reftable", name)] = data
+			fmt.Fprintln(&list, name)
+		}
+		out[filepath.Join(dir, f.name, "reftable", "tables.list")] = list.Bytes()
+	}
+	return out
+}
+
+func main() {
+	check := flag.Bool("check", false, "compare the fixtures on disk instead of writing them")
+	flag.Parse()
+
+	_, self, _, _ := runtime.Caller(0)
+	dir := filepath.Dir(self)
+
+	stale := false
+	for path, want := range files(dir) {
+		if *check {
+			if got, err := os.ReadFile(path); err != nil || !bytes.Equal(got, want) {
+				fmt.Fprintln(os.Stderr, "out of date:", path)
+				stale = true
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err := os.WriteFile(path, want, 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if stale {
+		os.Exit(1)
+	}
+}
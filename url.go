@@ -1,17 +1,59 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
 	"regexp"
+	"slices"
 	"strings"
 )
 
+// refKind is the namespace a ref name lives in. Most forges take any ref
+// where a branch goes; others spell tags and commits differently.
+type refKind int
+
+const (
+	refBranch refKind = iota
+	refTag
+	refCommit
+)
+
 // provider defines how to build URLs for a specific git hosting platform.
 type provider struct {
+	name       string // what gopen.<host>.type calls it
 	match      func(baseURL string) bool
-	treeURL    func(base, ref, path string) string
+	treeURL    func(base, ref, path string, kind refKind) string // kind is refBranch or refTag
 	commitURL  func(base, hash, path string) string
 	lineAnchor func(start, end string) string
+	// normalizeRemote turns a clone URL into the repository's web URL, for
+	// forges whose two differ by more than convertToHTTPS can undo. It
+	// reports false for a URL it does not recognise, which then gets the
+	// generic conversion.
+	normalizeRemote func(remoteURL string) (string, bool)
+	// changeRequestURL is the page for the pull/merge request whose source is
+	// branch, or the closest thing the forge can address without an API call.
+	// nil when the forge has no pull requests.
+	changeRequestURL func(base, branch string) string
+	// compareURL is the page comparing head against baseRef, the one a new
+	// pull request is opened from. nil when the forge has no such page.
+	compareURL func(base, baseRef, head string) string
+	// forkHead names branch in another repository on the same forge, in the
+	// form compareURL takes as head. nil when a compare across forks cannot be
+	// expressed as a URL.
+	forkHead func(forkBase, branch string) string
+	// blameURL is the blame (annotate) page of path at ref, a branch, tag or
+	// commit id as kind says. nil when the forge has no blame view.
+	blameURL func(base, ref, path string, kind refKind) string
+	// historyURL is the list of commits touching path, a file or directory
+	// ("" for the whole repository), reachable from ref. kind as for
+	// blameURL; nil when the forge cannot list history by URL.
+	historyURL func(base, ref, path string, kind refKind) string
+	// parsePage reads one of the repository's page URLs back into what it
+	// shows: the inverse of treeURL, commitURL and lineAnchor. Blame and
+	// history pages read back as the path they are about. It reports false
+	// for a URL that is not a page of base.
+	parsePage func(base string, page *url.URL) (webLocation, bool)
 }
 
 // pathJoin builds a slash-joined URL, skipping empty segments.
@@ -57,6 +99,16 @@ func anchorBB(start, end string) string { // Bitbucket: #lines-42 or #lines-42:5
 	return "#lines-" + start + ":" + end
 }
 
+func anchorBBS(start, end string) string { // Bitbucket Server: #42 or #42-50
+	if start == "" {
+		return ""
+	}
+	if end == "" {
+		return "#" + start
+	}
+	return "#" + start + "-" + end
+}
+
 func anchorADO(start, end string) string { // Azure DevOps: query params
 	if start == "" {
 		return ""
@@ -67,10 +119,106 @@ func anchorADO(start, end string) string { // Azure DevOps: query params
 	return fmt.Sprintf("&line=%s&lineEnd=%s&lineStartColumn=1&lineEndColumn=1", start, end)
 }
 
+// compareDots builds the "<prefix>compare/base...head" page GitHub, GitLab,
+// Gitea and Gogs share; GitLab nests it under "-/".
+func compareDots(prefix string) func(base, baseRef, head string) string {
+	return func(base, baseRef, head string) string {
+		return pathJoin(base, prefix+"compare", baseRef+"..."+head)
+	}
+}
+
+// ownerHead is the "owner:branch" head GitHub, Gitea and Gogs accept for a
+// branch in a fork.
+func ownerHead(forkBase, branch string) string {
+	owner, _, _ := strings.Cut(repoPath(forkBase), "/")
+	return owner + ":" + branch
+}
+
+// giteaScheme builds a provider on the URL scheme Gitea and its fork Forgejo
+// share. Every page that takes a ref names its kind too — src/branch/main,
+// src/tag/v1.0, src/commit/<sha> — because a bare name is ambiguous to them.
+func giteaScheme(name string, match func(string) bool) provider {
+	return provider{
+		name:  name,
+		match: match,
+		treeURL: func(base, ref, path string, kind refKind) string {
+			return giteaPage(base, "src", ref, path, kind)
+		},
+		commitURL: func(base, hash, path string) string {
+			if path == "" {
+				return pathJoin(base, "commit", hash)
+			}
+			return giteaPage(base, "src", hash, path, refCommit)
+		},
+		lineAnchor: anchorLN,
+		changeRequestURL: func(base, _ string) string {
+			return pathJoin(base, "pulls")
+		},
+		compareURL: compareDots(""),
+		forkHead:   ownerHead,
+		blameURL: func(base, ref, path string, kind refKind) string {
+			return giteaPage(base, "blame", ref, path, kind)
+		},
+		historyURL: func(base, ref, path string, kind refKind) string {
+			return giteaPage(base, "commits", ref, path, kind)
+		},
+		parsePage: parseGiteaPage,
+	}
+}
+
+// giteaPage returns the Gitea-scheme view ("src", "blame", "commits") of path
+// at ref.
+func giteaPage(base, view, ref, path string, kind refKind) string {
+	segment := "branch"
+	switch kind {
+	case refTag:
+		segment = "tag"
+	case refCommit:
+		segment = "commit"
+	}
+	return pathJoin(base, view, segment, ref, path)
+}
+
+// fullRefName spells ref out in full, refs/heads/<branch> or refs/tags/<tag>,
+// for forges that would otherwise take a name as either; a commit id stays as
+// it is.
+func fullRefName(ref string, kind refKind) string {
+	switch kind {
+	case refTag:
+		return "refs/tags/" + ref
+	case refCommit:
+		return ref
+	}
+	return headRefPrefix + ref
+}
+
+// azureVersion is Azure DevOps' version= value: the ref prefixed with GB for
+// a branch, GT for a tag or GC for a commit.
+func azureVersion(ref string, kind refKind) string {
+	switch kind {
+	case refTag:
+		return "GT" + ref
+	case refCommit:
+		return "GC" + ref
+	}
+	return "GB" + ref
+}
+
+// repoPath returns the path part of a repository's web URL, "owner/repo" on
+// most forges.
+func repoPath(baseURL string) string {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return ""
+	}
+	return strings.Trim(u.Path, "/")
+}
+
 var providers = []provider{
 	{
+		name:  "github",
 		match: func(u string) bool { return strings.Contains(u, "github.com") },
-		treeURL: func(base, ref, path string) string {
+		treeURL: func(base, ref, path string, _ refKind) string {
 			return pathJoin(base, "tree", ref, path)
 		},
 		commitURL: func(base, hash, path string) string {
@@ -80,12 +228,27 @@ var providers = []provider{
 			return pathJoin(base, "blob", hash, path)
 		},
 		lineAnchor: anchorLN,
+		// GitHub resolves /pull/<branch> to the open pull request for that
+		// branch, or to the compare page that creates one.
+		changeRequestURL: func(base, branch string) string {
+			return pathJoin(base, "pull", branch)
+		},
+		compareURL: compareDots(""),
+		forkHead:   ownerHead,
+		blameURL: func(base, ref, path string, _ refKind) string {
+			return pathJoin(base, "blame", ref, path)
+		},
+		historyURL: func(base, ref, path string, _ refKind) string {
+			return pathJoin(base, "commits", ref, path)
+		},
+		parsePage: parseRefPages("", "commit", "tree", "blob", "blame", "commits"),
 	},
 	{
+		name: "gitlab",
 		match: func(u string) bool {
 			return strings.Contains(u, "gitlab.com") || strings.Contains(u, "gitlab")
 		},
-		treeURL: func(base, ref, path string) string {
+		treeURL: func(base, ref, path string, _ refKind) string {
 			return pathJoin(base, "-/tree", ref, path)
 		},
 		commitURL: func(base, hash, path string) string {
@@ -95,10 +258,24 @@ var providers = []provider{
 			return pathJoin(base, "-/blob", hash, path)
 		},
 		lineAnchor: anchorGL,
+		changeRequestURL: func(base, branch string) string {
+			return pathJoin(base, "-/merge_requests") + "?source_branch=" + url.QueryEscape(branch)
+		},
+		// Merge requests across projects are keyed by project id, which a
+		// URL alone cannot supply: no forkHead.
+		compareURL: compareDots("-/"),
+		blameURL: func(base, ref, path string, _ refKind) string {
+			return pathJoin(base, "-/blame", ref, path)
+		},
+		historyURL: func(base, ref, path string, _ refKind) string {
+			return pathJoin(base, "-/commits", ref, path)
+		},
+		parsePage: parseRefPages("-/", "commit", "tree", "blob", "blame", "commits"),
 	},
 	{
+		name:  "bitbucket",
 		match: func(u string) bool { return strings.Contains(u, "bitbucket.org") },
-		treeURL: func(base, ref, path string) string {
+		treeURL: func(base, ref, path string, _ refKind) string {
 			return pathJoin(base, "src", ref, path)
 		},
 		commitURL: func(base, hash, path string) string {
@@ -108,16 +285,110 @@ var providers = []provider{
 			return pathJoin(base, "src", hash, path)
 		},
 		lineAnchor: anchorBB,
+		// Bitbucket Cloud has no per-branch address for a pull request; the
+		// list is the closest page.
+		changeRequestURL: func(base, _ string) string {
+			return pathJoin(base, "pull-requests")
+		},
+		// head and base are separated by a URL-encoded carriage return.
+		compareURL: func(base, baseRef, head string) string {
+			return pathJoin(base, "branches/compare", head+"%0D"+baseRef)
+		},
+		forkHead: func(forkBase, branch string) string {
+			return repoPath(forkBase) + ":" + branch
+		},
+		blameURL: func(base, ref, path string, _ refKind) string {
+			return pathJoin(base, "annotate", ref, path)
+		},
+		// history-node wants a path; the repository's own log lives
+		// elsewhere.
+		historyURL: func(base, ref, path string, _ refKind) string {
+			if path == "" {
+				return pathJoin(base, "commits/branch", ref)
+			}
+			return pathJoin(base, "history-node", ref, path)
+		},
+		parsePage: parseRefPages("", "commits", "src", "annotate", "history-node"),
+	},
+	{
+		// cgit, as on git.kernel.org. The path is in the URL and the ref in
+		// the query: h= for a branch, id= for a commit. Repositories keep
+		// their ".git", so normalizeRemote leaves it on. Detected ahead of
+		// Bitbucket Server: kernel.org's /pub/scm/ paths look like its /scm/.
+		name: "cgit",
+		match: func(u string) bool {
+			host := remoteHost(u)
+			return host == "git.kernel.org" || strings.HasPrefix(host, "cgit.")
+		},
+		normalizeRemote: gitDirWebURL,
+		treeURL: func(base, ref, path string, _ refKind) string {
+			return cgitPage(base, "tree", path, ref, refBranch)
+		},
+		commitURL: func(base, hash, path string) string {
+			if path == "" {
+				return cgitPage(base, "commit", "", hash, refCommit)
+			}
+			return cgitPage(base, "tree", path, hash, refCommit)
+		},
+		// cgit anchors single lines only; a range opens at its start.
+		lineAnchor: func(start, _ string) string {
+			if start == "" {
+				return ""
+			}
+			return "#n" + start
+		},
+		// Patches go to mailing lists: no changeRequestURL or compareURL.
+		blameURL: func(base, ref, path string, kind refKind) string {
+			return cgitPage(base, "blame", path, ref, kind)
+		},
+		historyURL: func(base, ref, path string, kind refKind) string {
+			return cgitPage(base, "log", path, ref, kind)
+		},
+		parsePage: parseCgitPage,
+	},
+	{
+		// Bitbucket Server and Data Center. Its clone URLs are recognisable —
+		// SSH on port 7999, HTTP under /scm/ — but its web URLs are not, so
+		// once normalizeRemote has rewritten one the provider is recorded by
+		// name rather than detected again.
+		name: "bitbucket-server",
+		match: func(u string) bool {
+			if parsed, err := url.Parse(u); err != nil || parsed.Scheme == "ssh" && parsed.Port() != "7999" {
+				return false
+			}
+			_, ok := bitbucketServerWebURL(u)
+			return ok
+		},
+		normalizeRemote: bitbucketServerWebURL,
+		treeURL: func(base, ref, path string, kind refKind) string {
+			return pathJoin(base, "browse", path) + "?at=" + fullRefName(ref, kind)
+		},
+		commitURL: func(base, hash, path string) string {
+			if path == "" {
+				return pathJoin(base, "commits", hash)
+			}
+			return pathJoin(base, "browse", path) + "?at=" + hash
+		},
+		lineAnchor: anchorBBS,
+		changeRequestURL: func(base, _ string) string {
+			return pathJoin(base, "pull-requests")
+		},
+		compareURL: func(base, baseRef, head string) string {
+			return pathJoin(base, "compare/commits") + "?sourceBranch=refs/heads/" + head + "&targetBranch=refs/heads/" + baseRef
+		},
+		parsePage: parseBitbucketServerPage,
 	},
 	{
+		name: "azure",
 		match: func(u string) bool {
 			return strings.Contains(u, "dev.azure.com") || strings.Contains(u, "visualstudio.com")
 		},
-		treeURL: func(base, ref, path string) string {
+		normalizeRemote: azureWebURL,
+		treeURL: func(base, ref, path string, kind refKind) string {
 			if path == "" {
-				return base + "?version=GB" + ref
+				return base + "?version=" + azureVersion(ref, kind)
 			}
-			return base + "?version=GB" + ref + "&path=/" + path
+			return base + "?version=" + azureVersion(ref, kind) + "&path=/" + path
 		},
 		commitURL: func(base, hash, path string) string {
 			if path == "" {
@@ -126,56 +397,187 @@ var providers = []provider{
 			return base + "?version=GC" + hash + "&path=/" + path
 		},
 		lineAnchor: anchorADO,
+		// Only the list of active pull requests is addressable by URL.
+		changeRequestURL: func(base, _ string) string {
+			return pathJoin(base, "pullrequests") + "?_a=active"
+		},
+		compareURL: func(base, baseRef, head string) string {
+			return pathJoin(base, "branchCompare") + "?baseVersion=GB" + baseRef + "&targetVersion=GB" + head
+		},
+		blameURL: func(base, ref, path string, kind refKind) string {
+			return base + "?version=" + azureVersion(ref, kind) + "&path=/" + path + "&_a=blame"
+		},
+		historyURL: func(base, ref, path string, kind refKind) string {
+			return base + "?version=" + azureVersion(ref, kind) + "&path=/" + path + "&_a=history"
+		},
+		parsePage: parseAzurePage,
 	},
+	giteaScheme("gitea", func(u string) bool { return strings.Contains(u, "gitea") }),
+	// Forgejo, which Codeberg runs, is a fork of Gitea and keeps its URLs.
+	giteaScheme("forgejo", func(u string) bool {
+		return strings.Contains(u, "codeberg.org") || strings.Contains(u, "forgejo")
+	}),
 	{
-		match: func(u string) bool { return strings.Contains(u, "gitea") },
-		treeURL: func(base, ref, path string) string {
-			return pathJoin(base, "src/branch", ref, path)
+		name:  "gogs",
+		match: func(u string) bool { return strings.Contains(u, "gogs") },
+		treeURL: func(base, ref, path string, _ refKind) string {
+			return pathJoin(base, "src", ref, path)
 		},
 		commitURL: func(base, hash, path string) string {
 			if path == "" {
 				return pathJoin(base, "commit", hash)
 			}
-			return pathJoin(base, "src/commit", hash, path)
+			return pathJoin(base, "src", hash, path)
 		},
 		lineAnchor: anchorLN,
+		changeRequestURL: func(base, _ string) string {
+			return pathJoin(base, "pulls")
+		},
+		compareURL: compareDots(""),
+		forkHead:   ownerHead,
+		// Gogs has no blame view: no blameURL.
+		historyURL: func(base, ref, path string, _ refKind) string {
+			return pathJoin(base, "commits", ref, path)
+		},
+		parsePage: parseRefPages("", "commit", "src", "commits"),
 	},
 	{
-		match: func(u string) bool { return strings.Contains(u, "gogs") },
-		treeURL: func(base, ref, path string) string {
-			return pathJoin(base, "src", ref, path)
+		// SourceHut keeps the ref and the path apart with an "item" segment,
+		// and has no pull requests: patches go to a mailing list.
+		name:  "sourcehut",
+		match: func(u string) bool { return strings.Contains(u, "sr.ht") },
+		treeURL: func(base, ref, path string, _ refKind) string {
+			return sourcehutPage(base, "tree", ref, path)
 		},
 		commitURL: func(base, hash, path string) string {
 			if path == "" {
 				return pathJoin(base, "commit", hash)
 			}
-			return pathJoin(base, "src", hash, path)
+			return sourcehutPage(base, "tree", hash, path)
 		},
-		lineAnchor: anchorLN,
+		lineAnchor: anchorGL,
+		blameURL: func(base, ref, path string, _ refKind) string {
+			return pathJoin(base, "blame", ref, path)
+		},
+		historyURL: func(base, ref, path string, _ refKind) string {
+			return sourcehutPage(base, "log", ref, path)
+		},
+		parsePage: parseSourcehutPage,
+	},
+	{
+		// Gitiles, the repository browser of Gerrit and of googlesource.com.
+		// Its clone URLs carry Gerrit's "/a/" prefix or SSH port, so
+		// normalizeRemote rewrites them and the provider is recorded by name.
+		name: "gitiles",
+		match: func(u string) bool {
+			return strings.Contains(u, "googlesource.com") || strings.Contains(u, "/plugins/gitiles/") ||
+				strings.Contains(u, ":29418/") || strings.HasPrefix(remoteHost(u), "gerrit.")
+		},
+		normalizeRemote: gitilesWebURL,
+		treeURL: func(base, ref, path string, kind refKind) string {
+			if path == "" {
+				// +/<rev> alone is the commit; the slash asks for its tree.
+				return pathJoin(base, "+", fullRefName(ref, kind)) + "/"
+			}
+			return pathJoin(base, "+", fullRefName(ref, kind), path)
+		},
+		commitURL: func(base, hash, path string) string {
+			return pathJoin(base, "+", hash, path)
+		},
+		// Gitiles anchors single lines only; a range opens at its start.
+		lineAnchor: func(start, _ string) string {
+			if start == "" {
+				return ""
+			}
+			return "#" + start
+		},
+		// Changes are pushed to refs/for/<target>, not under the local
+		// branch's name, so the project's open changes are the closest page.
+		// Gerrit has no compare view to open a change from: no compareURL.
+		changeRequestURL: func(base, _ string) string {
+			review, project := gerritProject(base)
+			return pathJoin(review, "q", "project:"+project+"+status:open")
+		},
+		blameURL: func(base, ref, path string, kind refKind) string {
+			return pathJoin(base, "+blame", fullRefName(ref, kind), path)
+		},
+		historyURL: func(base, ref, path string, kind refKind) string {
+			return pathJoin(base, "+log", fullRefName(ref, kind), path)
+		},
+		parsePage: parseGitilesPage,
 	},
 	{
+		// GitWeb, git's own CGI browser, taken to be served at the host's
+		// root. Everything is in the query: ?p=repo.git;a=blob;f=path;hb=ref.
+		name:            "gitweb",
+		match:           func(u string) bool { return strings.HasPrefix(remoteHost(u), "gitweb.") },
+		normalizeRemote: gitDirWebURL,
+		treeURL: func(base, ref, path string, _ refKind) string {
+			if path == "" {
+				return gitwebPage(base, "tree", "", "hb", ref)
+			}
+			// Without a=, GitWeb looks the path up and shows it as a blob
+			// or a tree, whichever it is.
+			return gitwebPage(base, "", path, "hb", ref)
+		},
+		commitURL: func(base, hash, path string) string {
+			if path == "" {
+				return gitwebPage(base, "commit", "", "h", hash)
+			}
+			return gitwebPage(base, "", path, "hb", hash)
+		},
+		// GitWeb anchors single lines only; a range opens at its start.
+		lineAnchor: func(start, _ string) string {
+			if start == "" {
+				return ""
+			}
+			return "#l" + start
+		},
+		blameURL: func(base, ref, path string, _ refKind) string {
+			return gitwebPage(base, "blame", path, "hb", ref)
+		},
+		historyURL: func(base, ref, path string, _ refKind) string {
+			if path == "" {
+				return gitwebPage(base, "log", "", "h", ref)
+			}
+			return gitwebPage(base, "history", path, "hb", ref)
+		},
+		parsePage: parseGitwebPage,
+	},
+	{
+		name: "codecommit",
 		match: func(u string) bool {
 			return strings.Contains(u, "console.aws.amazon.com") || strings.Contains(u, "codecommit")
 		},
-		treeURL: func(base, ref, path string) string {
+		normalizeRemote: codecommitWebURL,
+		treeURL: func(base, ref, path string, kind refKind) string {
 			if path == "" {
-				return pathJoin(base, "browse/refs/heads", ref, "--") + "/"
+				return codecommitPage(base, pathJoin("browse", fullRefName(ref, kind), "--")+"/")
 			}
-			return pathJoin(base, "browse/refs/heads", ref, "--", path)
+			return codecommitPage(base, pathJoin("browse", fullRefName(ref, kind), "--", path))
 		},
 		commitURL: func(base, hash, path string) string {
 			if path == "" {
-				return pathJoin(base, "commit", hash)
+				return codecommitPage(base, pathJoin("commit", hash))
 			}
-			return pathJoin(base, "browse", hash, "--", path)
+			return codecommitPage(base, pathJoin("browse", hash, "--", path))
 		},
 		lineAnchor: func(_, _ string) string { return "" }, // not supported
+		// The console has no blame view and no per-path history either: no
+		// blameURL or historyURL, so --blame and --history error instead of
+		// opening the plain file.
+		changeRequestURL: func(base, _ string) string {
+			return codecommitPage(base, "pull-requests")
+		},
+		parsePage: parseCodecommitPage,
 	},
 }
 
-// defaultProvider uses GitHub-style URLs as a fallback.
+// defaultProvider uses GitHub-style URLs as a fallback. It has a name of its
+// own, so a host nothing recognised is not reported as GitHub.
 var defaultProvider = provider{
-	treeURL: func(base, ref, path string) string {
+	name: "generic",
+	treeURL: func(base, ref, path string, _ refKind) string {
 		return pathJoin(base, "tree", ref, path)
 	},
 	commitURL: func(base, hash, path string) string {
@@ -185,6 +587,18 @@ var defaultProvider = provider{
 		return pathJoin(base, "blob", hash, path)
 	},
 	lineAnchor: anchorLN,
+	changeRequestURL: func(base, branch string) string {
+		return pathJoin(base, "pull", branch)
+	},
+	compareURL: compareDots(""),
+	forkHead:   ownerHead,
+	blameURL: func(base, ref, path string, _ refKind) string {
+		return pathJoin(base, "blame", ref, path)
+	},
+	historyURL: func(base, ref, path string, _ refKind) string {
+		return pathJoin(base, "commits", ref, path)
+	},
+	parsePage: parseRefPages("", "commit", "tree", "blob", "blame", "commits"),
 }
 
 func detectProvider(baseURL string) provider {
@@ -196,28 +610,515 @@ func detectProvider(baseURL string) provider {
 	return defaultProvider
 }
 
-func buildWebURL(ctx repoContext, lineNumber, commitHash string) string {
-	var startLine, endLine string
-	if lineNumber != "" {
-		parts := strings.SplitN(lineNumber, "-", 2)
-		startLine = parts[0]
-		if len(parts) > 1 {
-			endLine = parts[1]
+// splitLineRange splits a --line value, "42" or "42-50", into its start and
+// end; end is empty for a single line.
+func splitLineRange(lineNumber string) (start, end string) {
+	if lineNumber == "" {
+		return "", ""
+	}
+	start, end, _ = strings.Cut(lineNumber, "-")
+	return start, end
+}
+
+// providerByName returns the provider gopen.<host>.type names.
+func providerByName(name string) (provider, bool) {
+	for _, p := range providers {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return provider{}, false
+}
+
+// providerFor returns the provider for ctx: the one its host is bound to by
+// gopen.<host>.type or that remoteWebURL settled on, else the one its URL
+// looks like.
+func providerFor(ctx repoContext) provider {
+	if p, ok := providerByName(ctx.forge); ok {
+		return p
+	}
+	if ctx.forge == defaultProvider.name {
+		return defaultProvider
+	}
+	return detectProvider(ctx.baseURL)
+}
+
+// hostForge returns the provider name gopen.<host>.type binds baseURL's host
+// to, or "" when no setting does. The host is looked up with its port, then
+// without, so one setting can cover every port a forge is served on. An
+// unknown name is an error rather than a silent fall back to detection: the
+// setting exists precisely because detection gets this host wrong.
+func hostForge(hostTypes map[string]string, baseURL string) (string, error) {
+	if len(hostTypes) == 0 {
+		return "", nil
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Host == "" {
+		return "", nil
+	}
+	host := strings.ToLower(u.Host)
+	forge, ok := hostTypes[host]
+	if !ok {
+		host = strings.ToLower(u.Hostname())
+		if forge, ok = hostTypes[host]; !ok {
+			return "", nil
+		}
+	}
+	forge = strings.ToLower(forge)
+	if _, known := providerByName(forge); !known {
+		names := make([]string, len(providers))
+		for i, p := range providers {
+			names[i] = p.name
+		}
+		return "", fmt.Errorf("gopen.%s.type: unknown provider %q (known: %s)", host, forge, strings.Join(names, ", "))
+	}
+	return forge, nil
+}
+
+// remoteWebURL turns a remote's clone URL into the web URL of the repository,
+// and names the provider to build pages with: the one gopen.<host>.type binds
+// the host to, or the one whose normalizeRemote recognised the URL. A remote
+// that looks like a provider's but that its normalizeRemote rejects is not
+// that forge's, and names the default provider rather than leaving the
+// generic web URL to be detected a second time. An empty name leaves the
+// provider to be detected from the web URL.
+func remoteWebURL(remoteURL string, hostTypes map[string]string) (baseURL, forge string, err error) {
+	generic := convertToHTTPS(remoteURL)
+	forge, err = hostForge(hostTypes, generic)
+	if err != nil {
+		return "", "", err
+	}
+
+	p, bound := providerByName(forge)
+	if !bound {
+		p = detectProvider(remoteURL)
+	}
+	if p.normalizeRemote != nil {
+		if web, ok := p.normalizeRemote(remoteURL); ok {
+			return web, p.name, nil
+		}
+		if !bound {
+			return generic, defaultProvider.name, nil
+		}
+	}
+	return generic, forge, nil
+}
+
+// bitbucketServerWebURL maps a Bitbucket Server clone URL to its web URL:
+//
+//	ssh://git@host:7999/key/slug.git       → https://host/projects/KEY/repos/slug
+//	https://host[/context]/scm/key/slug.git → https://host[/context]/projects/KEY/repos/slug
+//
+// A "~user" key is a personal repository, served under /users/user. Project
+// keys are upper case in the web UI and lower case in clone URLs.
+func bitbucketServerWebURL(remoteURL string) (string, bool) {
+	u, err := url.Parse(strings.TrimSuffix(remoteURL, ".git"))
+	if err != nil || u.Host == "" {
+		return "", false
+	}
+
+	var web url.URL
+	var repoPath string
+	switch u.Scheme {
+	case "ssh":
+		// The SSH port is not the web server's.
+		web = url.URL{Scheme: "https", Host: u.Hostname()}
+		repoPath = strings.TrimPrefix(u.Path, "/")
+	case "http", "https":
+		// /scm/ is the segment right before key/slug; whatever precedes it
+		// is the context path.
+		segments := strings.Split(u.Path, "/")
+		n := len(segments)
+		if n < 4 || segments[n-3] != "scm" {
+			return "", false
+		}
+		web = url.URL{Scheme: u.Scheme, Host: u.Host, Path: strings.Join(segments[:n-3], "/")}
+		repoPath = strings.Join(segments[n-2:], "/")
+	default:
+		return "", false
+	}
+
+	key, slug, ok := strings.Cut(repoPath, "/")
+	if !ok || key == "" || slug == "" || strings.Contains(slug, "/") {
+		return "", false
+	}
+	if user, personal := strings.CutPrefix(key, "~"); personal {
+		return pathJoin(web.String(), "users", user, "repos", slug), true
+	}
+	return pathJoin(web.String(), "projects", strings.ToUpper(key), "repos", slug), true
+}
+
+// azureWebURL maps every Azure DevOps clone URL to the one web URL form,
+// https://dev.azure.com/org/project/_git/repo:
+//
+//	git@ssh.dev.azure.com:v3/org/project/repo
+//	ssh://git@ssh.dev.azure.com/v3/org/project/repo
+//	org@vs-ssh.visualstudio.com:v3/org/project/repo
+//	https://org@dev.azure.com/org/project/_git/repo
+//	https://org.visualstudio.com[/DefaultCollection]/project/_git/repo
+//
+// The SSH forms name no "_git" segment, and the HTTPS form carries the
+// organisation as user info, which a browser would prompt for.
+func azureWebURL(remoteURL string) (string, bool) {
+	if host, path, ok := sshRemote(remoteURL); ok {
+		if host != "ssh.dev.azure.com" && host != "vs-ssh.visualstudio.com" {
+			return "", false
+		}
+		parts := strings.Split(strings.TrimSuffix(path, "/"), "/")
+		if len(parts) != 4 || parts[0] != "v3" {
+			return "", false
+		}
+		return pathJoin("https://dev.azure.com", parts[1], parts[2], "_git", parts[3]), true
+	}
+
+	u, err := url.Parse(remoteURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return "", false
+	}
+	path := strings.Trim(u.EscapedPath(), "/")
+	if !strings.Contains("/"+path+"/", "/_git/") {
+		return "", false
+	}
+	host := strings.ToLower(u.Hostname())
+	switch {
+	case host == "dev.azure.com":
+		return "https://dev.azure.com/" + path, true
+	case strings.HasSuffix(host, ".visualstudio.com"):
+		org := strings.TrimSuffix(host, ".visualstudio.com")
+		path = strings.TrimPrefix(path, "DefaultCollection/")
+		return pathJoin("https://dev.azure.com", org, path), true
+	}
+	return "", false
+}
+
+// sourcehutPage builds SourceHut's "<view>/<ref>[/item/<path>]" pages.
+func sourcehutPage(base, view, ref, path string) string {
+	if path == "" {
+		return pathJoin(base, view, ref)
+	}
+	return pathJoin(base, view, ref, "item", path)
+}
+
+// gitilesWebURL maps a Gerrit clone URL to the project's Gitiles page:
+//
+//	https://android.googlesource.com/a/platform/build
+//	ssh://jdoe@gerrit.corp.example:29418/platform/build
+//	https://gerrit.corp.example/a/platform/build
+//
+// become https://android.googlesource.com/platform/build and
+// https://gerrit.corp.example/plugins/gitiles/platform/build: googlesource.com
+// serves Gitiles at the root, any other Gerrit under its gitiles plugin. "/a/"
+// is the prefix of Gerrit's authenticated HTTP URLs.
+func gitilesWebURL(remoteURL string) (string, bool) {
+	var web url.URL
+	var project string
+	if host, path, ok := sshRemote(remoteURL); ok {
+		// The SSH port is not the web server's.
+		web = url.URL{Scheme: "https", Host: host}
+		project = path
+	} else {
+		u, err := url.Parse(remoteURL)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return "", false
+		}
+		if strings.Contains(u.Path, "/plugins/gitiles/") {
+			return convertToHTTPS(remoteURL), true // already a web URL
+		}
+		web = url.URL{Scheme: u.Scheme, Host: u.Host}
+		project = strings.TrimPrefix(strings.TrimPrefix(u.Path, "/"), "a/")
+	}
+	project = strings.TrimSuffix(strings.Trim(project, "/"), ".git")
+	if project == "" {
+		return "", false
+	}
+	if strings.HasSuffix(strings.ToLower(web.Hostname()), ".googlesource.com") {
+		return pathJoin(web.String(), project), true
+	}
+	return pathJoin(web.String(), "plugins/gitiles", project), true
+}
+
+// gerritProject splits a Gitiles web URL into the Gerrit instance that reviews
+// the project and the project's name. googlesource.com runs each host's Gerrit
+// on a "-review" host beside it.
+func gerritProject(base string) (review, project string) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return base, ""
+	}
+	if prefix, name, ok := strings.Cut(u.Path, "/plugins/gitiles/"); ok {
+		u.Path = prefix
+		return strings.TrimSuffix(u.String(), "/"), name
+	}
+	project = strings.Trim(u.Path, "/")
+	u.Path = ""
+	if name, ok := strings.CutSuffix(strings.ToLower(u.Host), ".googlesource.com"); ok && !strings.HasSuffix(name, "-review") {
+		u.Host = name + "-review.googlesource.com"
+	}
+	return u.String(), project
+}
+
+// gitDirWebURL is convertToHTTPS for browsers that name a repository by its
+// directory on the server, ".git" included, as cgit and GitWeb do:
+// git://git.kernel.org/pub/scm/git/git.git becomes
+// https://git.kernel.org/pub/scm/git/git.git.
+func gitDirWebURL(remoteURL string) (string, bool) {
+	if host, path, ok := sshRemote(remoteURL); ok {
+		return pathJoin("https://"+host, strings.Trim(path, "/")), true
+	}
+	u, err := url.Parse(remoteURL)
+	if err != nil || u.Host == "" {
+		return "", false
+	}
+	var web url.URL
+	switch u.Scheme {
+	case "git":
+		// The git daemon's port is not the web server's.
+		web = url.URL{Scheme: "https", Host: u.Hostname()}
+	case "http", "https":
+		web = url.URL{Scheme: u.Scheme, Host: u.Host}
+	default:
+		return "", false
+	}
+	return pathJoin(web.String(), strings.Trim(u.Path, "/")), true
+}
+
+// cgitPage builds cgit's "<view>/<path>?h=<ref>" pages, with id= in place of
+// h= for a commit. h= takes a tag as readily as a branch.
+func cgitPage(base, view, path, ref string, kind refKind) string {
+	page := pathJoin(base, view, path)
+	if path == "" {
+		page += "/"
+	}
+	if kind == refCommit {
+		return page + "?id=" + ref
+	}
+	return page + "?h=" + ref
+}
+
+// gitwebPage builds a GitWeb URL for the repository at base:
+// ?p=<repo>[;a=<action>][;f=<path>];<revKey>=<rev>.
+func gitwebPage(base, action, path, revKey, rev string) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		return base
+	}
+	params := []string{"p=" + strings.Trim(u.Path, "/")}
+	if action != "" {
+		params = append(params, "a="+action)
+	}
+	if path != "" {
+		params = append(params, "f="+path)
+	}
+	params = append(params, revKey+"="+rev)
+	u.Path = "/"
+	return u.String() + "?" + strings.Join(params, ";")
+}
+
+// codecommitWebURL maps an AWS CodeCommit clone URL to the repository's page
+// in the console:
+//
+//	codecommit::us-east-1://profile@repo                    (git-remote-codecommit)
+//	codecommit://profile@repo                               (region from the profile)
+//	https://git-codecommit.us-east-1.amazonaws.com/v1/repos/repo
+//	ssh://KEYID@git-codecommit.us-east-1.amazonaws.com/v1/repos/repo
+//
+// all become https://us-east-1.console.aws.amazon.com/codesuite/codecommit/repositories/repo.
+// When the region is left to the AWS profile it cannot be known here, and the
+// region-less console URL lets the console pick its default.
+func codecommitWebURL(remoteURL string) (string, bool) {
+	var region, repo string
+	if rest, ok := strings.CutPrefix(remoteURL, "codecommit:"); ok {
+		var found bool
+		if after, noRegion := strings.CutPrefix(rest, "//"); noRegion {
+			repo = after
+		} else if region, repo, found = strings.Cut(strings.TrimPrefix(rest, ":"), "://"); !found {
+			return "", false
+		}
+		if _, name, hasProfile := strings.Cut(repo, "@"); hasProfile {
+			repo = name
+		}
+	} else {
+		u, err := url.Parse(remoteURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "ssh") {
+			return "", false
+		}
+		m := codecommitHost.FindStringSubmatch(strings.ToLower(u.Hostname()))
+		if m == nil {
+			return "", false
+		}
+		region = m[1]
+		var found bool
+		if repo, found = strings.CutPrefix(u.Path, "/v1/repos/"); !found {
+			return "", false
+		}
+	}
+	repo = strings.TrimSuffix(repo, "/")
+	if repo == "" || strings.Contains(repo, "/") {
+		return "", false
+	}
+
+	console := "https://console.aws.amazon.com"
+	if region != "" {
+		console = "https://" + region + ".console.aws.amazon.com"
+	}
+	return pathJoin(console, "codesuite/codecommit/repositories", repo), true
+}
+
+// codecommitHost matches a CodeCommit Git endpoint and captures its region.
+var codecommitHost = regexp.MustCompile(`^git-codecommit(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com$`)
+
+// codecommitPage appends page to a repository's console URL, and the region
+// query the console needs to open it in the right region — read back from the
+// host codecommitWebURL put it in.
+func codecommitPage(base, page string) string {
+	u := pathJoin(base, page)
+	host := base
+	if rest, ok := strings.CutPrefix(host, "https://"); ok {
+		host, _, _ = strings.Cut(rest, "/")
+	}
+	if region, ok := strings.CutSuffix(host, ".console.aws.amazon.com"); ok {
+		return u + "?region=" + region
+	}
+	return u
+}
+
+// remoteHost returns the lower-cased host, without port, of a clone or web
+// URL in any form sshRemote or url.Parse reads; "" when there is none. It lets
+// detection look at the host alone, so a repository named after a forge is
+// not taken for one.
+func remoteHost(remoteURL string) string {
+	if host, _, ok := sshRemote(remoteURL); ok {
+		return strings.ToLower(host)
+	}
+	u, err := url.Parse(remoteURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// sshRemote splits an SSH remote, in either the scp-like "user@host:path" or
+// the "ssh://user@host[:port]/path" form, into its host and path. It reports
+// false for anything else, local paths included.
+func sshRemote(remoteURL string) (host, path string, ok bool) {
+	if rest, found := strings.CutPrefix(remoteURL, "ssh://"); found {
+		authority, path, found := strings.Cut(rest, "/")
+		if !found {
+			return "", "", false
+		}
+		if _, hostPort, hasUser := strings.Cut(authority, "@"); hasUser {
+			authority = hostPort
 		}
+		host, _, _ = strings.Cut(authority, ":")
+		return host, path, host != ""
 	}
+	if strings.Contains(remoteURL, "://") {
+		return "", "", false
+	}
+	// git reads "host:path" as scp-like only when the colon comes before any
+	// slash; otherwise it is a local path.
+	authority, path, found := strings.Cut(remoteURL, ":")
+	if !found || strings.Contains(authority, "/") {
+		return "", "", false
+	}
+	if _, h, hasUser := strings.Cut(authority, "@"); hasUser {
+		authority = h
+	}
+	return authority, path, authority != ""
+}
 
-	p := detectProvider(ctx.baseURL)
+// viewRef returns what a page for ctx is at: commitHash when one is given, and
+// otherwise the branch or tag, unless HEAD is detached. No forge has a branch
+// named HEAD, so a detached HEAD is shown at the commit it holds.
+func viewRef(ctx repoContext, commitHash string) (string, refKind) {
+	switch {
+	case commitHash != "":
+		return commitHash, refCommit
+	case ctx.branch == detachedHEAD && ctx.commit != "":
+		return ctx.commit, refCommit
+	}
+	return ctx.branch, ctx.refKind
+}
+
+func buildWebURL(ctx repoContext, lineNumber, commitHash string) string {
+	startLine, endLine := splitLineRange(lineNumber)
+
+	p := providerFor(ctx)
 
 	var url string
-	if commitHash != "" {
-		url = p.commitURL(ctx.baseURL, commitHash, ctx.relPath)
+	if ref, kind := viewRef(ctx, commitHash); kind == refCommit {
+		url = p.commitURL(ctx.baseURL, ref, ctx.relPath)
 	} else {
-		url = p.treeURL(ctx.baseURL, ctx.branch, ctx.relPath)
+		url = p.treeURL(ctx.baseURL, ref, ctx.relPath, kind)
 	}
 
 	return url + p.lineAnchor(startLine, endLine)
 }
 
+// buildChangeRequestURL returns the URL of the pull/merge request for the
+// branch checked out in ctx. It is pure URL construction: whether such a
+// request exists is for the forge to say once the page loads.
+func buildChangeRequestURL(ctx repoContext) (string, error) {
+	if ctx.branch == detachedHEAD {
+		return "", errors.New("HEAD is detached: there is no branch to find a pull request for")
+	}
+	p := providerFor(ctx)
+	if p.changeRequestURL == nil {
+		return "", fmt.Errorf("no pull request view is known for %s", ctx.baseURL)
+	}
+	return p.changeRequestURL(ctx.baseURL, ctx.branch), nil
+}
+
+// buildBlameURL returns the blame page for the file in ctx, at commitHash when
+// one is given and at the branch, or a detached HEAD's commit, otherwise, with
+// the same line anchor buildWebURL would add.
+func buildBlameURL(ctx repoContext, lineNumber, commitHash string) (string, error) {
+	if ctx.relPath == "" {
+		return "", errors.New("--blame needs a file")
+	}
+	p := providerFor(ctx)
+	if p.blameURL == nil {
+		return "", fmt.Errorf("no blame view is known for %s", ctx.baseURL)
+	}
+	ref, kind := viewRef(ctx, commitHash)
+	startLine, endLine := splitLineRange(lineNumber)
+	return p.blameURL(ctx.baseURL, ref, ctx.relPath, kind) + p.lineAnchor(startLine, endLine), nil
+}
+
+// buildHistoryURL returns the commit log for the path in ctx, which may be a
+// directory or the repository root, starting from commitHash when one is given
+// and from the branch, or a detached HEAD's commit, otherwise.
+func buildHistoryURL(ctx repoContext, commitHash string) (string, error) {
+	p := providerFor(ctx)
+	if p.historyURL == nil {
+		return "", fmt.Errorf("no history view is known for %s", ctx.baseURL)
+	}
+	ref, kind := viewRef(ctx, commitHash)
+	return p.historyURL(ctx.baseURL, ref, ctx.relPath, kind), nil
+}
+
+// buildCompareURL returns the URL comparing the branch checked out in ctx
+// against baseRef, on ctx's remote. forkBase is the web URL of the remote the
+// branch is pushed to; when it is another repository the head is qualified so
+// the forge looks for the branch there.
+func buildCompareURL(ctx repoContext, baseRef, forkBase string) (string, error) {
+	if ctx.branch == detachedHEAD {
+		return "", errors.New("HEAD is detached: there is no branch to compare")
+	}
+	p := providerFor(ctx)
+	if p.compareURL == nil {
+		return "", fmt.Errorf("no compare view is known for %s", ctx.baseURL)
+	}
+	head := ctx.branch
+	if forkBase != "" && forkBase != ctx.baseURL {
+		if p.forkHead == nil {
+			return "", fmt.Errorf("comparing against a fork is not supported for %s", ctx.baseURL)
+		}
+		head = p.forkHead(forkBase, ctx.branch)
+	}
+	return p.compareURL(ctx.baseURL, baseRef, head), nil
+}
+
 func convertToHTTPS(url string) string {
 	url = strings.TrimSuffix(url, ".git")
 
@@ -239,3 +1140,375 @@ func convertToHTTPS(url string) string {
 
 	return url
 }
+
+// webLocation is what a page URL shows, as parsePage reads it back.
+type webLocation struct {
+	ref   string // branch, tag or commit id; "" when the URL names none
+	kind  refKind
+	path  string // path from the repository root; "" for the root
+	start string // first highlighted line; "" for none
+	end   string // last line of a range; "" for a single line
+	// refPath is "<ref>/<path>" as written by a forge that does not mark
+	// where a ref with a slash in it ends. ref and path are empty then, for
+	// splitRefPath to fill in from the repository's refs.
+	refPath string
+}
+
+// pageRest returns page's path below base's, without the slash between
+// them, when page is on base's host. Forges treat owner and repository names
+// case-insensitively, and so does this.
+func pageRest(base string, page *url.URL) (string, bool) {
+	b, err := url.Parse(base)
+	if err != nil || !strings.EqualFold(b.Host, page.Host) {
+		return "", false
+	}
+	prefix := strings.TrimSuffix(b.Path, "/")
+	if len(page.Path) < len(prefix) || !strings.EqualFold(page.Path[:len(prefix)], prefix) {
+		return "", false
+	}
+	rest := page.Path[len(prefix):]
+	if rest != "" && rest[0] != '/' {
+		return "", false
+	}
+	return strings.Trim(rest, "/"), true
+}
+
+// lineFragment matches every anchor lineAnchor writes: L42-L50, L42-50,
+// lines-42:50, 42-50, n42 and l42.
+var lineFragment = regexp.MustCompile(`^(?:L|lines-|n|l)?([0-9]+)(?:[-:]L?([0-9]+))?$`)
+
+// parseLineFragment reads a line or range back from a URL fragment.
+func parseLineFragment(fragment string) (start, end string) {
+	m := lineFragment.FindStringSubmatch(fragment)
+	if m == nil {
+		return "", ""
+	}
+	return m[1], m[2]
+}
+
+// looksLikeCommit reports whether s could be an abbreviated or full commit id.
+func looksLikeCommit(s string) bool {
+	if len(s) < 7 || len(s) > 64 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < '0' || c > '9') && (c < 'a' || c > 'f') && (c < 'A' || c > 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// refFromName reads a ref back from a forge that names it in full, as
+// fullRefName writes it, or by a commit id.
+func refFromName(name string) (string, refKind) {
+	if branch, ok := strings.CutPrefix(name, headRefPrefix); ok {
+		return branch, refBranch
+	}
+	if tag, ok := strings.CutPrefix(name, "refs/tags/"); ok {
+		return tag, refTag
+	}
+	if looksLikeCommit(name) {
+		return name, refCommit
+	}
+	return name, refBranch
+}
+
+// splitRefPath splits a webLocation's refPath after the shortest leading
+// segments isRef accepts as a ref. A commit id has no slash and needs no
+// lookup. When nothing matches the first segment is the ref, which is right
+// for every ref without a slash.
+func splitRefPath(refPath string, isRef func(string) bool) (ref, path string) {
+	segments := strings.Split(refPath, "/")
+	if !looksLikeCommit(segments[0]) {
+		for i := 1; i <= len(segments); i++ {
+			if name := strings.Join(segments[:i], "/"); isRef(name) {
+				return name, strings.Join(segments[i:], "/")
+			}
+		}
+	}
+	return segments[0], strings.Join(segments[1:], "/")
+}
+
+// splitRef is loc with its refPath, if any, taken apart by splitRefPath.
+func (loc webLocation) splitRef(isRef func(string) bool) webLocation {
+	if loc.refPath == "" {
+		return loc
+	}
+	loc.ref, loc.path = splitRefPath(loc.refPath, isRef)
+	loc.refPath = ""
+	if looksLikeCommit(loc.ref) {
+		loc.kind = refCommit
+	}
+	return loc
+}
+
+// parseRefPages builds the parsePage of a forge whose pages are
+// <prefix><view>/<ref>/<path> for each of views, and <prefix><commitView>/<id>
+// for a commit.
+func parseRefPages(prefix, commitView string, views ...string) func(string, *url.URL) (webLocation, bool) {
+	return func(base string, page *url.URL) (webLocation, bool) {
+		rest, ok := pageRest(base, page)
+		if !ok {
+			return webLocation{}, false
+		}
+		var loc webLocation
+		loc.start, loc.end = parseLineFragment(page.Fragment)
+		if rest == "" {
+			return loc, true
+		}
+		if rest, ok = strings.CutPrefix(rest, prefix); !ok {
+			return webLocation{}, false
+		}
+		view, after, _ := strings.Cut(rest, "/")
+		switch {
+		case after == "":
+			return webLocation{}, false
+		case view == commitView:
+			if strings.Contains(after, "/") {
+				return webLocation{}, false
+			}
+			return webLocation{ref: after, kind: refCommit}, true
+		case slices.Contains(views, view):
+			loc.refPath = after
+			return loc, true
+		}
+		return webLocation{}, false
+	}
+}
+
+// parseGiteaPage reads back the pages giteaScheme builds, which name the kind
+// of ref: src/branch/<ref>/<path>, src/tag/..., src/commit/<id>/<path>.
+func parseGiteaPage(base string, page *url.URL) (webLocation, bool) {
+	rest, ok := pageRest(base, page)
+	if !ok {
+		return webLocation{}, false
+	}
+	var loc webLocation
+	loc.start, loc.end = parseLineFragment(page.Fragment)
+	if rest == "" {
+		return loc, true
+	}
+	parts := strings.SplitN(rest, "/", 3)
+	if len(parts) == 2 && parts[0] == "commit" {
+		return webLocation{ref: parts[1], kind: refCommit}, true
+	}
+	if len(parts) != 3 || (parts[0] != "src" && parts[0] != "blame" && parts[0] != "commits") {
+		return webLocation{}, false
+	}
+	switch parts[1] {
+	case "branch":
+		loc.kind = refBranch
+	case "tag":
+		loc.kind = refTag
+	case "commit":
+		loc.kind = refCommit
+		loc.ref, loc.path, _ = strings.Cut(parts[2], "/")
+		return loc, true
+	default:
+		return webLocation{}, false
+	}
+	loc.refPath = parts[2]
+	return loc, true
+}
+
+// parseSourcehutPage reads back SourceHut's pages, where "item" ends the ref:
+// tree/<ref>/item/<path>, log/..., and commit/<id>. Its blame page has no
+// such marker.
+func parseSourcehutPage(base string, page *url.URL) (webLocation, bool) {
+	rest, ok := pageRest(base, page)
+	if !ok {
+		return webLocation{}, false
+	}
+	var loc webLocation
+	loc.start, loc.end = parseLineFragment(page.Fragment)
+	if rest == "" {
+		return loc, true
+	}
+	view, after, _ := strings.Cut(rest, "/")
+	if after == "" {
+		return webLocation{}, false
+	}
+	switch view {
+	case "commit":
+		return webLocation{ref: after, kind: refCommit}, true
+	case "tree", "log":
+		loc.ref, loc.path, _ = strings.Cut(after, "/item/")
+	case "blame":
+		loc.refPath = after
+		return loc, true
+	default:
+		return webLocation{}, false
+	}
+	if looksLikeCommit(loc.ref) {
+		loc.kind = refCommit
+	}
+	return loc, true
+}
+
+// parseCgitPage reads back cgitPage's <view>/<path>?h=<ref> and ?id=<commit>.
+func parseCgitPage(base string, page *url.URL) (webLocation, bool) {
+	rest, ok := pageRest(base, page)
+	if !ok {
+		return webLocation{}, false
+	}
+	var loc webLocation
+	loc.start, loc.end = parseLineFragment(page.Fragment)
+	if rest == "" {
+		return loc, true
+	}
+	view, path, _ := strings.Cut(rest, "/")
+	switch view {
+	case "tree", "blame", "log", "commit":
+	default:
+		return webLocation{}, false
+	}
+	query := page.Query()
+	if id := query.Get("id"); id != "" {
+		loc.ref, loc.kind = id, refCommit
+	} else {
+		loc.ref = query.Get("h")
+	}
+	if view != "commit" {
+		loc.path = path
+	}
+	return loc, true
+}
+
+// parseBitbucketServerPage reads back browse/<path>?at=<ref> and
+// commits/<id>.
+func parseBitbucketServerPage(base string, page *url.URL) (webLocation, bool) {
+	rest, ok := pageRest(base, page)
+	if !ok {
+		return webLocation{}, false
+	}
+	var loc webLocation
+	loc.start, loc.end = parseLineFragment(page.Fragment)
+	if id, ok := strings.CutPrefix(rest, "commits/"); ok && !strings.Contains(id, "/") {
+		return webLocation{ref: id, kind: refCommit}, true
+	}
+	if rest != "browse" && !strings.HasPrefix(rest, "browse/") && rest != "" {
+		return webLocation{}, false
+	}
+	loc.path = strings.TrimPrefix(strings.TrimPrefix(rest, "browse"), "/")
+	if at := page.Query().Get("at"); at != "" {
+		loc.ref, loc.kind = refFromName(at)
+	}
+	return loc, true
+}
+
+// parseAzurePage reads back Azure DevOps' ?version=GB<ref>&path=/<path>, with
+// its line= and lineEnd= selection, and commit/<id>.
+func parseAzurePage(base string, page *url.URL) (webLocation, bool) {
+	rest, ok := pageRest(base, page)
+	if !ok {
+		return webLocation{}, false
+	}
+	if id, ok := strings.CutPrefix(rest, "commit/"); ok && !strings.Contains(id, "/") {
+		return webLocation{ref: id, kind: refCommit}, true
+	}
+	if rest != "" {
+		return webLocation{}, false
+	}
+	query := page.Query()
+	loc := webLocation{path: strings.TrimPrefix(query.Get("path"), "/")}
+	if version := query.Get("version"); len(version) > 2 {
+		loc.ref = version[2:]
+		switch version[:2] {
+		case "GB":
+			loc.kind = refBranch
+		case "GT":
+			loc.kind = refTag
+		case "GC":
+			loc.kind = refCommit
+		default:
+			return webLocation{}, false
+		}
+	}
+	loc.start, loc.end = query.Get("line"), query.Get("lineEnd")
+	if loc.end == loc.start {
+		loc.end = ""
+	}
+	return loc, true
+}
+
+// parseGitilesPage reads back +/<rev>/<path>, +blame/ and +log/, where rev is
+// a full ref name or a commit id.
+func parseGitilesPage(base string, page *url.URL) (webLocation, bool) {
+	rest, ok := pageRest(base, page)
+	if !ok {
+		return webLocation{}, false
+	}
+	var loc webLocation
+	loc.start, loc.end = parseLineFragment(page.Fragment)
+	if rest == "" {
+		return loc, true
+	}
+	view, after, _ := strings.Cut(rest, "/")
+	if (view != "+" && view != "+blame" && view != "+log") || after == "" {
+		return webLocation{}, false
+	}
+	switch {
+	case strings.HasPrefix(after, headRefPrefix):
+		loc.refPath = strings.TrimPrefix(after, headRefPrefix)
+	case strings.HasPrefix(after, "refs/tags/"):
+		loc.refPath, loc.kind = strings.TrimPrefix(after, "refs/tags/"), refTag
+	default:
+		loc.refPath = after
+	}
+	return loc, true
+}
+
+// parseGitwebPage reads back gitwebPage's ?p=<repo>;a=<action>;f=<path>;hb=<rev>.
+func parseGitwebPage(base string, page *url.URL) (webLocation, bool) {
+	b, err := url.Parse(base)
+	if err != nil || !strings.EqualFold(b.Host, page.Host) {
+		return webLocation{}, false
+	}
+	params := make(map[string]string)
+	for _, param := range strings.FieldsFunc(page.RawQuery, func(r rune) bool { return r == ';' || r == '&' }) {
+		key, value, _ := strings.Cut(param, "=")
+		if v, err := url.QueryUnescape(value); err == nil {
+			params[key] = v
+		}
+	}
+	if params["p"] != strings.Trim(b.Path, "/") {
+		return webLocation{}, false
+	}
+	loc := webLocation{path: params["f"]}
+	loc.start, loc.end = parseLineFragment(page.Fragment)
+	loc.ref = params["hb"]
+	if loc.ref == "" {
+		loc.ref = params["h"]
+	}
+	loc.ref, loc.kind = refFromName(loc.ref)
+	return loc, true
+}
+
+// parseCodecommitPage reads back browse/<ref>/--/<path> and commit/<id>.
+func parseCodecommitPage(base string, page *url.URL) (webLocation, bool) {
+	rest, ok := pageRest(base, page)
+	if !ok {
+		return webLocation{}, false
+	}
+	if id, ok := strings.CutPrefix(rest, "commit/"); ok && !strings.Contains(id, "/") {
+		return webLocation{ref: id, kind: refCommit}, true
+	}
+	if rest == "" {
+		return webLocation{}, true
+	}
+	after, ok := strings.CutPrefix(rest, "browse/")
+	if !ok {
+		return webLocation{}, false
+	}
+	// pageRest dropped the slash a root page ends in; put one back so that
+	// "--" always has one on either side.
+	ref, path, found := strings.Cut(after+"/", "/--/")
+	if !found {
+		return webLocation{}, false
+	}
+	var loc webLocation
+	loc.ref, loc.kind = refFromName(ref)
+	loc.path = strings.TrimSuffix(path, "/")
+	return loc, true
+}